@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Directory inside the data directory where the accounts and sessions
+// are stored
+const authDir = ".auth"
+
+// Name of the session cookie
+const sessionCookie = "was_session"
+
+// Min and max length of passwords (bcrypt uses at most 72 bytes)
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+// Interval of removing expired sessions
+const sessionPruneInterval = time.Hour
+
+// Hash of a random password, which is checked instead of the hash of a
+// user that doesn't exist, so that logins of unknown users takes the
+// same time
+var unknownUserHash = sync.OnceValue(func() []byte {
+	password := make([]byte, 32)
+	rand.Read(password)
+	return []byte(hashPassword(base64.RawStdEncoding.EncodeToString(password)))
+})
+
+// Valid user names
+var userNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]{0,63}$`)
+
+// account is a stored user account
+type account struct {
+	User     string    `json:"user"`
+	Password string    `json:"password"` // bcrypt hash of the password
+	Created  time.Time `json:"created"`
+}
+
+// session is a stored login session. The session token itself is not
+// stored, only its hash.
+type session struct {
+	User    string    `json:"user"`
+	Expires time.Time `json:"expires"`
+}
+
+// authRequest is the body of register and login
+type authRequest struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// auth handles the user accounts and sessions
+type auth struct {
+	dir    string        // Directory of the accounts and sessions
+	maxAge time.Duration // Lifetime of a session
+	mutex  sync.Mutex    // Serializes access to the accounts and sessions
+}
+
+// Key of the current user in the request context
+type userKey struct{}
+
+func newAuth(dir string, maxAge time.Duration) *auth {
+	return &auth{dir: dir, maxAge: maxAge}
+}
+
+// Hashes a password with bcrypt, which includes a random salt. The
+// password must be at most maxPasswordLength bytes.
+func hashPassword(password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err) // Only fails for too long passwords or if rand fails
+	}
+	return string(hash)
+}
+
+// Checks a password against a hash created by hashPassword
+func checkPassword(password string, hash string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// Returns the file name of a session, which is the hash of the token
+func (a *auth) sessionPath(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return path.Join(a.dir, "sessions", hex.EncodeToString(sum[:])+".json")
+}
+
+func (a *auth) accountPath(user string) string {
+	return path.Join(a.dir, "users", user+".json")
+}
+
+// Reads a stored JSON file into v
+func readJSONFile(fileName string, v interface{}) error {
+	dat, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(dat, v)
+}
+
+// Writes v as JSON to a file, creating the directory if needed
+func writeJSONFile(fileName string, v interface{}) error {
+	err := os.MkdirAll(path.Dir(fileName), 0777)
+	if err != nil {
+		return err
+	}
+	dat, _ := json.Marshal(v)
+	return writeFileAtomic(fileName, dat, 0600)
+}
+
+// Creates a new account
+func (a *auth) register(user string, password string) error {
+	if !userNameRegexp.MatchString(user) {
+		return &statusError{http.StatusBadRequest, "Invalid user name: " + user}
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return &statusError{http.StatusBadRequest, fmt.Sprintf("Password must be %d to %d characters",
+			minPasswordLength, maxPasswordLength)}
+	}
+	hash := hashPassword(password)
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if _, err := os.Stat(a.accountPath(user)); err == nil {
+		return &statusError{http.StatusConflict, "User " + user + " already exists"}
+	}
+	return writeJSONFile(a.accountPath(user), account{User: user, Password: hash, Created: time.Now().UTC()})
+}
+
+// Checks the credentials of a user
+func (a *auth) authenticate(user string, password string) error {
+	var acc account
+	if !userNameRegexp.MatchString(user) || readJSONFile(a.accountPath(user), &acc) != nil {
+		// Spend the same time as for an existing user
+		bcrypt.CompareHashAndPassword(unknownUserHash(), []byte(password))
+		return &statusError{http.StatusUnauthorized, "Invalid user or password"}
+	}
+	if !checkPassword(password, acc.Password) {
+		return &statusError{http.StatusUnauthorized, "Invalid user or password"}
+	}
+	return nil
+}
+
+// Creates a new session for user and returns the session token
+func (a *auth) createSession(user string) (string, error) {
+	tokenBytes := make([]byte, 32)
+	_, err := rand.Read(tokenBytes)
+	if err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return token, writeJSONFile(a.sessionPath(token),
+		session{User: user, Expires: time.Now().Add(a.maxAge).UTC()})
+}
+
+// Removes expired sessions
+func (a *auth) pruneSessions() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	dir := path.Join(a.dir, "sessions")
+	files, _ := os.ReadDir(dir)
+	for _, file := range files {
+		var s session
+		fullPath := path.Join(dir, file.Name())
+		if readJSONFile(fullPath, &s) != nil || time.Now().After(s.Expires) {
+			os.Remove(fullPath)
+		}
+	}
+}
+
+// Runs pruneSessions periodically until stop is closed
+func (a *auth) runSessionPruner(stop chan struct{}) {
+	ticker := time.NewTicker(sessionPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.pruneSessions()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Returns the user of a session token ("" if the session is invalid)
+func (a *auth) sessionUser(token string) string {
+	var s session
+	if token == "" || readJSONFile(a.sessionPath(token), &s) != nil || time.Now().After(s.Expires) {
+		return ""
+	}
+	return s.User
+}
+
+// Removes a session
+func (a *auth) removeSession(token string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	os.Remove(a.sessionPath(token))
+}
+
+// Returns the session token of a request ("" if none)
+func sessionToken(r *http.Request) string {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// Returns the logged in user of a request ("" if not logged in). The
+// user is available for handlers wrapped by withUser.
+func userOf(r *http.Request) string {
+	user, _ := r.Context().Value(userKey{}).(string)
+	return user
+}
+
+// Wraps a handler so that the logged in user is available by userOf. If
+// requireAuth is configured and the handler modifies data, requests
+// without a logged in user are rejected with 401 Unauthorized.
+func (wa *WebAPI) withUser(handler http.HandlerFunc, modifies bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := wa.auth.sessionUser(sessionToken(r))
+		if user == "" && wa.config.RequireAuth && modifies {
+			messageResponse(w, http.StatusUnauthorized, "Login required")
+			return
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	}
+}
+
+// Reads the body of register and login
+func (wa *WebAPI) readAuthRequest(w http.ResponseWriter, r *http.Request) (authRequest, bool) {
+	var req authRequest
+	body, ok := wa.readJSONBody(w, r)
+	if !ok {
+		return req, false
+	}
+	err := json.Unmarshal(body, &req)
+	if err != nil {
+		messageResponse(w, http.StatusBadRequest, err.Error())
+		return req, false
+	}
+	return req, true
+}
+
+// Starts a session for user and sets the session cookie
+func (wa *WebAPI) startSession(w http.ResponseWriter, user string) bool {
+	token, err := wa.auth.createSession(user)
+	if err != nil {
+		messageResponse(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(wa.auth.maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   wa.tlsCertFile != "" && wa.tlsKeyFile != "",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return true
+}
+
+func (wa *WebAPI) handleRegister(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("POST REGISTER")
+	req, ok := wa.readAuthRequest(w, r)
+	if !ok {
+		return
+	}
+	err := wa.auth.register(req.User, req.Password)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	if wa.startSession(w, req.User) {
+		writeUser(w, http.StatusCreated, req.User)
+	}
+}
+
+func (wa *WebAPI) handleLogin(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("POST LOGIN")
+	req, ok := wa.readAuthRequest(w, r)
+	if !ok {
+		return
+	}
+	err := wa.auth.authenticate(req.User, req.Password)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	if wa.startSession(w, req.User) {
+		writeUser(w, http.StatusOK, req.User)
+	}
+}
+
+func (wa *WebAPI) handleLogout(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("POST LOGOUT")
+	if token := sessionToken(r); token != "" {
+		wa.auth.removeSession(token)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	messageResponse(w, http.StatusOK, "Logged out")
+}
+
+func (wa *WebAPI) handleUserGet(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("GET USER")
+	user := wa.auth.sessionUser(sessionToken(r))
+	if user == "" {
+		messageResponse(w, http.StatusUnauthorized, "Not logged in")
+		return
+	}
+	writeUser(w, http.StatusOK, user)
+}
+
+func writeUser(w http.ResponseWriter, status int, user string) {
+	response, _ := json.Marshal(map[string]string{"user": user})
+	writeResponseStr(w, status, string(response))
+}
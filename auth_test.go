@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash := hashPassword("secret password")
+	assertTrue(t, hash, strings.HasPrefix(hash, "$2a$10$"))
+	assertTrue(t, "Salted", hash != hashPassword("secret password"))
+	assertTrue(t, "", checkPassword("secret password", hash))
+	assertFalse(t, "", checkPassword("secret passwore", hash))
+	assertFalse(t, "", checkPassword("", ""))
+	assertFalse(t, "", checkPassword("x", "$2a$10$abc"))
+	assertFalse(t, "", checkPassword("x", "md5$1$abc$abc"))
+	assertFalse(t, "Unknown user", checkPassword("", string(unknownUserHash())))
+}
+
+func TestAuth(t *testing.T) {
+	a := newAuth(t.TempDir(), time.Hour)
+
+	assertExpectNoErr(t, "", a.register("alice", "password1"))
+	assertExpectErr(t, "Exists", a.register("alice", "password2"))
+	assertExpectErr(t, "Short password", a.register("bob", "short"))
+	assertExpectErr(t, "Long password", a.register("bob", strings.Repeat("x", 73)))
+	assertExpectNoErr(t, "", a.register("bob", strings.Repeat("x", 72)))
+	for _, invalid := range []string{"", ".hidden", "a/b", "../x", strings.Repeat("x", 65)} {
+		assertExpectErr(t, invalid, a.register(invalid, "password1"))
+	}
+
+	assertExpectNoErr(t, "", a.authenticate("alice", "password1"))
+	assertExpectErr(t, "", a.authenticate("alice", "password2"))
+	assertExpectErr(t, "", a.authenticate("bob", "password1"))
+	assertExpectErr(t, "", a.authenticate("../alice", "password1"))
+
+	token, err := a.createSession("alice")
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", "alice", a.sessionUser(token))
+	assertEqualsStr(t, "", "", a.sessionUser("invalid"))
+	assertEqualsStr(t, "", "", a.sessionUser(""))
+	a.removeSession(token)
+	assertEqualsStr(t, "Logged out", "", a.sessionUser(token))
+
+	// Expired sessions
+	valid, _ := a.createSession("alice")
+	a.maxAge = -time.Second
+	token, _ = a.createSession("alice")
+	assertEqualsStr(t, "", "", a.sessionUser(token))
+	assertFileExist(t, "", a.sessionPath(token))
+	a.pruneSessions()
+	assertFileNotExist(t, "", a.sessionPath(token))
+	assertEqualsStr(t, "", "alice", a.sessionUser(valid))
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// batchRequest is the body of POST /service/batch
+type batchRequest struct {
+	Ops []batchOperation `json:"ops"`
+}
+
+// batchOperation is one operation of a batch
+type batchOperation struct {
+	Op          string          `json:"op"`          // get, put, delete or patch
+	Path        string          `json:"path"`        // Object path, such as myapp/game/1
+	Body        json.RawMessage `json:"body"`        // Object (put) or patch (patch)
+	ContentType string          `json:"contentType"` // Patch format (default merge patch)
+	IfMatch     string          `json:"ifMatch"`     // Same as the If-Match header
+	IfNoneMatch string          `json:"ifNoneMatch"` // Same as the If-None-Match header
+	TTL         string          `json:"ttl"`         // Time-to-live of put and patch, such as 1h
+}
+
+// batchResult is the result of one operation of a batch
+type batchResult struct {
+	Status  int             `json:"status"`
+	ETag    string          `json:"etag,omitempty"`
+	Body    json.RawMessage `json:"body,omitempty"`
+	Message string          `json:"message,omitempty"`
+}
+
+// batchObject is the state of an object during a batch
+type batchObject struct {
+	data            []byte       // Current contents within the batch
+	exists          bool         // Object exists within the batch
+	original        []byte       // Contents before the batch
+	originalMeta    objectMeta   // Metadata before the batch
+	originalModTime time.Time    // Modification time before the batch
+	existed         bool         // Object existed before the batch
+	changed         bool         // Object has been modified by the batch
+	opts            writeOptions // Options of the write of the object
+	event           string       // Event of the commit (create, update or delete)
+	latestRev       int          // Latest revision in the history before the commit
+	createdDir      string       // Topmost directory created by the commit ("" = none)
+}
+
+func (wa *WebAPI) handleBatch(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("POST BATCH")
+	body, ok := wa.readJSONBody(w, r)
+	if !ok {
+		return
+	}
+	var batch batchRequest
+	err := json.Unmarshal(body, &batch)
+	if err != nil {
+		messageResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	wa.mutex.Lock()
+	defer wa.mutex.Unlock()
+
+	// Apply all operations on the staged objects
+	objects := make(map[string]*batchObject)
+	var order []string // Modified objects in the order they were first modified
+	var rels []string  // Object of each operation
+	var reads []bool   // Operation is a get of the contents before the batch
+	results := []batchResult{}
+	for i, op := range batch.Ops {
+		rel, result, err := wa.batchOperation(op, objects)
+		rels = append(rels, rel)
+		reads = append(reads, err == nil && op.Op == "get" && !objects[rel].changed)
+		if err != nil {
+			statusErr := toStatusError(err)
+			results = append(results, batchResult{Status: statusErr.status, Message: statusErr.message})
+			batchResponse(w, statusErr.status,
+				fmt.Sprintf("Operation %d failed: %s", i, statusErr.message), results)
+			return
+		}
+		results = append(results, result)
+		if objects[rel].changed && !slices.Contains(order, rel) {
+			order = append(order, rel)
+		}
+	}
+
+	// Commit the modified objects. If anything fails, the already
+	// committed objects (and the failing object, which might be partly
+	// written) are rolled back in reverse order. The gets of contents
+	// before the batch are still valid, but all other operations are
+	// failed. Nobody is informed about the modifications until all
+	// objects are committed.
+	for i, rel := range order {
+		object := objects[rel]
+		object.opts.user = userOf(r)
+		object.latestRev = wa.history.latest(rel)
+		object.createdDir = wa.missingDir(rel)
+		if object.exists {
+			object.event, err = wa.writeObject(rel, object.data, object.opts)
+		} else if object.existed {
+			object.event, err = "delete", wa.deleteObject(rel)
+		}
+		if err != nil {
+			for j := i; j >= 0; j-- {
+				wa.rollbackObject(order[j], objects[order[j]])
+			}
+			statusErr := toStatusError(err)
+			for j, opRel := range rels {
+				if reads[j] {
+					continue
+				}
+				if opRel == rel {
+					results[j] = batchResult{Status: statusErr.status, Message: statusErr.message}
+				} else {
+					results[j] = batchResult{Status: http.StatusFailedDependency, Message: "Rolled back"}
+				}
+			}
+			batchResponse(w, statusErr.status, statusErr.message, results)
+			return
+		}
+	}
+	for _, rel := range order {
+		if event := objects[rel].event; event != "" {
+			wa.modified(event, rel, objects[rel].data)
+		}
+	}
+	batchResponse(w, http.StatusOK, "Batch successful", results)
+}
+
+// Applies one batch operation on the staged objects. Returns the path
+// of the object relative the data directory and the result.
+func (wa *WebAPI) batchOperation(op batchOperation,
+	objects map[string]*batchObject) (string, batchResult, error) {
+	rel, err := batchPath(op.Path)
+	if err != nil {
+		return "", batchResult{}, err
+	}
+	object, isStaged := objects[rel]
+	if !isStaged {
+		data, err := wa.readObject(rel)
+		object = &batchObject{data: data, exists: err == nil, original: data,
+			originalMeta: wa.readMeta(rel), existed: err == nil}
+		if info, err := os.Stat(path.Join(wa.dataPath, rel)); err == nil {
+			object.originalModTime = info.ModTime()
+		}
+		objects[rel] = object
+	}
+	if op.TTL != "" {
+		ttl, err := time.ParseDuration(op.TTL)
+		if err != nil || ttl < 0 {
+			return rel, batchResult{}, &statusError{http.StatusBadRequest, "Invalid TTL: " + op.TTL}
+		}
+		object.opts.ttl = &ttl
+	}
+	etag := etagOf(object.data)
+	if op.IfMatch != "" && !etagListMatches(op.IfMatch, etag, object.exists) {
+		return rel, batchResult{}, &statusError{http.StatusPreconditionFailed, "ETag mismatch"}
+	}
+	if op.IfNoneMatch != "" && etagListMatches(op.IfNoneMatch, etag, object.exists) {
+		return rel, batchResult{}, &statusError{http.StatusPreconditionFailed, "Object already exists"}
+	}
+	switch op.Op {
+	case "get":
+		if !object.exists {
+			return rel, batchResult{}, &statusError{http.StatusNotFound, "Object " + rel + " not found"}
+		}
+		return rel, batchResult{Status: http.StatusOK, ETag: etag, Body: object.data}, nil
+	case "put":
+		if len(op.Body) == 0 {
+			return rel, batchResult{}, &statusError{http.StatusBadRequest, "body missing"}
+		}
+		err = wa.validateSchema(rel, op.Body)
+		if err != nil {
+			return rel, batchResult{}, err
+		}
+		object.data, object.exists, object.changed = op.Body, true, true
+		return rel, batchResult{Status: http.StatusOK, ETag: etagOf(op.Body)}, nil
+	case "delete":
+		if !object.exists {
+			return rel, batchResult{}, &statusError{http.StatusNotFound, "Object " + rel + " not found"}
+		}
+		object.data, object.exists, object.changed = nil, false, true
+		return rel, batchResult{Status: http.StatusOK}, nil
+	case "patch":
+		if !object.exists && strings.TrimSpace(op.IfNoneMatch) != "*" {
+			return rel, batchResult{}, &statusError{http.StatusNotFound, "Object " + rel + " not found"}
+		}
+		contentType := op.ContentType
+		if contentType == "" {
+			contentType = mergePatchType
+		}
+		patched, err := applyPatch(contentType, object.data, object.exists, op.Body)
+		if err == nil {
+			err = wa.validateStored(patched)
+		}
+		if err == nil {
+			err = wa.validateSchema(rel, patched)
+		}
+		if err != nil {
+			return rel, batchResult{}, err
+		}
+		object.data, object.exists, object.changed = patched, true, true
+		return rel, batchResult{Status: http.StatusOK, ETag: etagOf(patched), Body: patched}, nil
+	}
+	return rel, batchResult{}, &statusError{http.StatusBadRequest, fmt.Sprintf("invalid op %q", op.Op)}
+}
+
+// Returns the topmost parent directory of object rel that don't exist
+// ("" if the parent directory exists)
+func (wa *WebAPI) missingDir(rel string) string {
+	missing := ""
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if _, err := os.Stat(path.Join(wa.dataPath, dir)); err == nil {
+			break
+		}
+		missing = dir
+	}
+	return missing
+}
+
+// Restores an object committed by a batch to its state before the
+// batch, including its metadata, history and created directories
+func (wa *WebAPI) rollbackObject(rel string, object *batchObject) {
+	fullPath := path.Join(wa.dataPath, rel)
+	if object.existed {
+		current, err := os.ReadFile(fullPath)
+		if err != nil || !bytes.Equal(current, object.original) {
+			writeFileAtomic(fullPath, object.original, 0777)
+			os.Chtimes(fullPath, object.originalModTime, object.originalModTime)
+		}
+	} else if info, err := os.Stat(fullPath); err == nil && !info.IsDir() {
+		os.Remove(fullPath)
+	}
+	if object.createdDir != "" {
+		// Directories that are not empty remain
+		for dir := path.Dir(rel); ; dir = path.Dir(dir) {
+			os.Remove(path.Join(wa.dataPath, dir))
+			if dir == object.createdDir {
+				break
+			}
+		}
+	}
+	wa.writeMeta(rel, object.originalMeta)
+	wa.history.removeAfter(rel, object.latestRev)
+	wa.resetUsage(rel)
+}
+
+// Converts the path of a batch operation, with or without the /data/
+// prefix, to an object path relative the data directory.
+func batchPath(p string) (string, error) {
+	dir, file, err := dirAndJsonFile("/data/" + strings.TrimPrefix(strings.TrimPrefix(p, "/"), "data/"))
+	if err != nil {
+		return "", &statusError{http.StatusForbidden, err.Error()}
+	}
+	if file == "" {
+		return "", &statusError{http.StatusBadRequest, "Batch operations on directories not allowed"}
+	}
+	return path.Join(dir, file), nil
+}
+
+func batchResponse(w http.ResponseWriter, status int, message string, results []batchResult) {
+	response, _ := json.Marshal(map[string]interface{}{
+		"message": message,
+		"results": results,
+	})
+	writeResponseStr(w, status, string(response))
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBatchPath(t *testing.T) {
+	rel, err := batchPath("myapp/game/1")
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", "myapp/game/1.json", rel)
+
+	rel, err = batchPath("/data/myapp/game/1")
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", "myapp/game/1.json", rel)
+
+	rel, err = batchPath("obj")
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", "obj.json", rel)
+
+	_, err = batchPath("myapp/game/")
+	assertExpectErr(t, "", err)
+	_, err = batchPath("../secret")
+	assertExpectErr(t, "", err)
+	_, err = batchPath("myapp/.history/x")
+	assertExpectErr(t, "", err)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Config holds the server configuration. All values have defaults which
+// can be overridden by a JSON configuration file.
+type Config struct {
+	MaxBodySize     int64 `json:"maxBodySize"`     // Max size of POST body in bytes
+	MaxDepth        int   `json:"maxDepth"`        // Max nesting depth of JSON data
+	MaxStringLength int   `json:"maxStringLength"` // Max length of JSON strings
+
+	HistoryMaxCount int      `json:"historyMaxCount"` // Revisions kept per object (0 = none, -1 = unlimited)
+	HistoryMaxAge   Duration `json:"historyMaxAge"`   // Max age of revisions (0 = unlimited)
+
+	TTL              map[string]Duration `json:"ttl"`              // TTL of objects per directory
+	TTLSweepInterval Duration            `json:"ttlSweepInterval"` // Interval of deleting expired objects
+
+	Quotas map[string]Quota `json:"quotas"` // Quota per app ("*" = apps without own quota)
+
+	Webhooks          []WebhookRule `json:"webhooks"`          // Webhooks called when data is modified
+	WebhookRetries    int           `json:"webhookRetries"`    // Max retries of a failed webhook call
+	WebhookRetryDelay Duration      `json:"webhookRetryDelay"` // Delay before the first retry (doubled each retry)
+
+	RequireAuth   bool     `json:"requireAuth"`   // Only logged in users can modify data
+	SessionMaxAge Duration `json:"sessionMaxAge"` // Lifetime of a login session
+
+	WebSocketOrigins []string `json:"webSocketOrigins"` // Other origins allowed to join rooms ("*" = all)
+}
+
+// WebhookRule calls a webhook when objects matching a path prefix are
+// modified
+type WebhookRule struct {
+	Prefix string `json:"prefix"` // Object path prefix, such as myapp/scores/ ("" = all)
+	URL    string `json:"url"`    // URL which the events are posted to
+	Secret string `json:"secret"` // Key of the HMAC-SHA256 signature ("" = no signature)
+}
+
+// Quota limits the storage used by an app, i.e. a top level directory
+// in the data directory
+type Quota struct {
+	MaxBytes   int64 `json:"maxBytes"`   // Max total size of the objects (0 = unlimited)
+	MaxObjects int   `json:"maxObjects"` // Max number of objects (0 = unlimited)
+}
+
+// Duration is a time.Duration which is represented as a string, such as
+// "1h30m", in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// DefaultConfig creates a configuration with default values
+func DefaultConfig() *Config {
+	return &Config{
+		MaxBodySize:       10 * 1024 * 1024,
+		MaxDepth:          64,
+		MaxStringLength:   1024 * 1024,
+		HistoryMaxCount:   10,
+		TTLSweepInterval:  Duration(time.Minute),
+		WebhookRetries:    5,
+		WebhookRetryDelay: Duration(time.Second),
+		SessionMaxAge:     Duration(30 * 24 * time.Hour),
+	}
+}
+
+// LoadConfig reads a JSON configuration file. Values not set in the
+// file keeps their default values. An empty file name returns the
+// default configuration.
+func LoadConfig(fileName string) (*Config, error) {
+	config := DefaultConfig()
+	if fileName == "" {
+		return config, nil
+	}
+	dat, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(dat, config)
+	if err != nil {
+		return nil, err
+	}
+	ttl := make(map[string]Duration, len(config.TTL))
+	for dir, duration := range config.TTL {
+		ttl[configDir(dir)] = duration
+	}
+	config.TTL = ttl
+	return config, nil
+}
+
+// Returns directory dir of the configuration relative the data
+// directory, so that for example "/data/myapp/invites/", "myapp/invites/"
+// and "myapp/invites" are the same directory. The data directory itself
+// is ".".
+func configDir(dir string) string {
+	dir = strings.TrimPrefix(path.Clean("/"+dir), "/")
+	dir = strings.TrimPrefix(dir, "data/")
+	if dir == "" {
+		return "."
+	}
+	return dir
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	// No file gives the defaults
+	config, err := LoadConfig("")
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 64, config.MaxDepth)
+
+	// Values in file overrides defaults
+	fileName := path.Join(t.TempDir(), "config.json")
+	os.WriteFile(fileName, []byte(`{"maxDepth": 5}`), 0666)
+	config, err = LoadConfig(fileName)
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 5, config.MaxDepth)
+	assertEqualsInt(t, "", int(DefaultConfig().MaxBodySize), int(config.MaxBodySize))
+
+	// Directories of TTLs are relative the data directory
+	os.WriteFile(fileName, []byte(`{"ttl": {"/data/a/invites/": "1h", "b/": "2h", "/c": "3h", "/": "4h"}}`), 0666)
+	config, err = LoadConfig(fileName)
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 4, len(config.TTL))
+	for _, dir := range []string{"a/invites", "b", "c", "."} {
+		_, exists := config.TTL[dir]
+		assertTrue(t, dir, exists)
+	}
+
+	// File that don't exist
+	_, err = LoadConfig("file/dont/exist.json")
+	assertExpectErr(t, "", err)
+
+	// Invalid file
+	os.WriteFile(fileName, []byte(`{"maxDepth": `), 0666)
+	_, err = LoadConfig(fileName)
+	assertExpectErr(t, "", err)
+}
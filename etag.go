@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Creates a strong ETag (including the quotes) from the contents of
+// an object or a directory aggregate.
+func etagOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Checks if an If-Match or If-None-Match header value matches etag. The
+// header value is either * (matches any existing entity) or a comma
+// separated list of ETags. Strong comparison is used, thus weak ETags
+// (W/"...") never match.
+func etagListMatches(header string, etag string, exists bool) bool {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return exists
+	}
+	if !exists {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimSpace(candidate) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluates the If-Match precondition of a modifying request. Returns
+// true if the request shall proceed, i.e. if no If-Match header was
+// given or if it matches the current state of the entity.
+func ifMatchOk(r *http.Request, etag string, exists bool) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	return etagListMatches(header, etag, exists)
+}
+
+// Evaluates the If-None-Match precondition of a modifying request.
+// Returns true if the request shall proceed, i.e. if no If-None-Match
+// header was given or if it don't match the current state of the
+// entity. If-None-Match: * thus only proceeds if the entity don't exist.
+func ifNoneMatchOk(r *http.Request, etag string, exists bool) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return true
+	}
+	return !etagListMatches(header, etag, exists)
+}
+
+// Sets the ETag and Last-Modified headers of a GET response and
+// evaluates the If-None-Match and If-Modified-Since headers. Returns
+// true, after responding with 304 Not Modified, if the client already
+// has the current contents. A zero modTime means that the modification
+// time is unknown. Weak comparison is used for If-None-Match and
+// If-Modified-Since is ignored if If-None-Match is given (RFC 9110).
+// Last-Modified is not set for contents modified within the current
+// second, since it can't tell such contents from a later modification
+// within the same second.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if secondPassed(modTime) {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		if !etagListMatches(strings.ReplaceAll(header, "W/", ""), etag, true) {
+			return false
+		}
+	} else if !notModifiedSince(r, modTime) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// Checks if the If-Modified-Since header of a request is given and is
+// not before modTime. The header has a resolution of seconds, thus it
+// is not used if modTime is within the current second (or later).
+func notModifiedSince(r *http.Request, modTime time.Time) bool {
+	if !secondPassed(modTime) || r.Header.Get("If-None-Match") != "" {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !modTime.Truncate(time.Second).After(since)
+}
+
+// Checks if modTime is known and within a second that has passed, i.e.
+// if no later modification can have the same Last-Modified
+func secondPassed(modTime time.Time) bool {
+	return !modTime.IsZero() && modTime.Truncate(time.Second).Before(time.Now().Truncate(time.Second))
+}
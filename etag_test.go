@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEtagOf(t *testing.T) {
+	etag := etagOf([]byte(`{"a": 1}`))
+	assertEqualsInt(t, "", 34, len(etag))
+	assertTrue(t, "", etag[0] == '"' && etag[len(etag)-1] == '"')
+	assertEqualsStr(t, "Same content same ETag", etag, etagOf([]byte(`{"a": 1}`)))
+	assertTrue(t, "Different content different ETag", etag != etagOf([]byte(`{"a": 2}`)))
+}
+
+func TestEtagListMatches(t *testing.T) {
+	etag := `"abc"`
+	assertTrue(t, "", etagListMatches(`"abc"`, etag, true))
+	assertTrue(t, "", etagListMatches(` "xyz", "abc" `, etag, true))
+	assertTrue(t, "", etagListMatches(`*`, etag, true))
+	assertFalse(t, "", etagListMatches(`*`, etag, false))
+	assertFalse(t, "", etagListMatches(`"abc"`, etag, false))
+	assertFalse(t, "", etagListMatches(`"xyz"`, etag, true))
+	assertFalse(t, "Weak ETags never match", etagListMatches(`W/"abc"`, etag, true))
+}
+
+func TestIfNoneMatchOk(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/data/obj", nil)
+	assertTrue(t, "No header", ifNoneMatchOk(req, `"abc"`, true))
+
+	req.Header.Set("If-None-Match", "*")
+	assertTrue(t, "", ifNoneMatchOk(req, "", false))
+	assertFalse(t, "", ifNoneMatchOk(req, `"abc"`, true))
+
+	req.Header.Set("If-None-Match", `"abc"`)
+	assertFalse(t, "", ifNoneMatchOk(req, `"abc"`, true))
+	assertTrue(t, "", ifNoneMatchOk(req, `"xyz"`, true))
+}
+
+func TestNotModified(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 10, 0, 0, 500, time.UTC)
+	check := func(headers map[string]string, expected bool) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/data/obj", nil)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		assertEqualsBool(t, fmt.Sprint(headers), expected, notModified(w, req, `"abc"`, modTime))
+		return w
+	}
+	w := check(nil, false)
+	assertEqualsStr(t, "", `"abc"`, w.Header().Get("ETag"))
+	assertEqualsStr(t, "", "Wed, 01 May 2024 10:00:00 GMT", w.Header().Get("Last-Modified"))
+
+	w = check(map[string]string{"If-None-Match": `"abc"`}, true)
+	assertEqualsInt(t, "", http.StatusNotModified, w.Code)
+	check(map[string]string{"If-None-Match": `"xyz", W/"abc"`}, true)
+	check(map[string]string{"If-None-Match": `*`}, true)
+	check(map[string]string{"If-None-Match": `"xyz"`}, false)
+
+	check(map[string]string{"If-Modified-Since": "Wed, 01 May 2024 10:00:00 GMT"}, true)
+	check(map[string]string{"If-Modified-Since": "Wed, 01 May 2024 11:00:00 GMT"}, true)
+	check(map[string]string{"If-Modified-Since": "Wed, 01 May 2024 09:59:59 GMT"}, false)
+	check(map[string]string{"If-Modified-Since": "yesterday"}, false)
+
+	// If-None-Match has precedence
+	check(map[string]string{"If-None-Match": `"xyz"`,
+		"If-Modified-Since": "Wed, 01 May 2024 11:00:00 GMT"}, false)
+
+	// Modified within the current second, thus a later modification
+	// within the same second would have the same Last-Modified
+	modTime = time.Now()
+	w = check(nil, false)
+	assertEqualsStr(t, "", "", w.Header().Get("Last-Modified"))
+	check(map[string]string{"If-Modified-Since": modTime.UTC().Format(http.TimeFormat)}, false)
+	check(map[string]string{"If-Modified-Since": modTime.Add(time.Hour).UTC().Format(http.TimeFormat)}, false)
+}
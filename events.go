@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Number of events kept for resuming an event stream with Last-Event-ID
+const maxChangeEvents = 1000
+
+// Interval of comments sent on an idle event stream, so that proxies
+// don't close the connection
+const eventKeepAlive = 30 * time.Second
+
+// changeEvent is an event of the change feed
+type changeEvent struct {
+	Seq   uint64    `json:"-"`              // Sequence number of the event
+	Event string    `json:"-"`              // created, updated or deleted
+	Path  string    `json:"path"`           // Object (or directory ending with /) path, such as /data/myapp/obj
+	ETag  string    `json:"etag,omitempty"` // ETag of the new contents (not for deleted)
+	Time  time.Time `json:"time"`           // Time of the modification
+}
+
+// changeLog keeps the latest events of the change feed, so that
+// streams can be resumed, and notifies the streams about new events
+type changeLog struct {
+	mutex   sync.Mutex
+	boot    string        // Identifies this run of the server in the event IDs
+	next    uint64        // Sequence number of the next event
+	events  []changeEvent // The latest events, oldest first
+	changed chan struct{} // Closed when an event is added
+}
+
+func newChangeLog() *changeLog {
+	return &changeLog{
+		boot:    strconv.FormatInt(time.Now().UnixNano(), 36),
+		next:    1,
+		changed: make(chan struct{}),
+	}
+}
+
+// Returns the ID of the event with sequence number seq, which is sent as
+// the id of the event and received as Last-Event-ID when resuming
+func (l *changeLog) id(seq uint64) string {
+	return fmt.Sprintf("%s-%d", l.boot, seq)
+}
+
+// Adds an event about object rel (or directory rel ending with /)
+// relative the data directory. event is create, update or delete and
+// data is the new contents of the object (nil for delete).
+func (l *changeLog) add(event string, rel string, data []byte) {
+	e := changeEvent{
+		Event: event + "d", // created, updated or deleted
+		Path:  "/data/" + strings.TrimSuffix(rel, ".json"),
+		Time:  time.Now().UTC(),
+	}
+	if data != nil {
+		e.ETag = etagOf(data)
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	e.Seq = l.next
+	l.next++
+	l.events = append(l.events, e)
+	if len(l.events) > maxChangeEvents {
+		l.events = l.events[len(l.events)-maxChangeEvents:]
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// Returns the sequence number of the last event ("" = the latest event)
+// given by a Last-Event-ID. Returns false if the ID is invalid, from
+// another run of the server or too old to resume from.
+func (l *changeLog) position(lastID string) (uint64, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if lastID == "" {
+		return l.next - 1, true
+	}
+	boot, seqStr, _ := strings.Cut(lastID, "-")
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if boot != l.boot || err != nil || seq >= l.next {
+		return l.next - 1, false
+	}
+	if len(l.events) > 0 && seq+1 < l.events[0].Seq {
+		return l.next - 1, false
+	}
+	return seq, true
+}
+
+// Returns the events after sequence number seq and a channel which is
+// closed when another event is added
+func (l *changeLog) since(seq uint64) ([]changeEvent, <-chan struct{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var result []changeEvent
+	for _, e := range l.events {
+		if e.Seq > seq {
+			result = append(result, e)
+		}
+	}
+	return result, l.changed
+}
+
+// Checks if object (or directory ending with /) p starts with prefix,
+// such as a directory ending with / ("" for all objects). Deleting a
+// directory is within all prefixes within the directory.
+func withinPrefix(prefix string, p string) bool {
+	return strings.HasPrefix(p, prefix) ||
+		(strings.HasSuffix(p, "/") && strings.HasPrefix(prefix, p))
+}
+
+// Writes one server-sent event
+func writeEvent(w http.ResponseWriter, id string, event string, data []byte) {
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, event, data)
+}
+
+// Handles GET /events/data/<dirs>/, which streams created, updated and
+// deleted events of the objects within the directory as server-sent
+// events. A client that reconnects with Last-Event-ID gets the events
+// it missed. If they are not available anymore a reset event is sent,
+// after which the client needs to read the data again.
+func (wa *WebAPI) handleEvents(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("EVENTS " + r.URL.Path)
+	prefix := strings.TrimPrefix(r.URL.Path, "/events")
+	dir, file, err := dirAndJsonFile(prefix)
+	if err != nil {
+		messageResponse(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if file != "" {
+		messageResponse(w, http.StatusBadRequest, "Events are only available for directories (ending with /)")
+		return
+	}
+	prefix = strings.TrimPrefix(dir+"/", "./")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		messageResponse(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	seq, found := wa.events.position(r.Header.Get("Last-Event-ID"))
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if !found {
+		writeEvent(w, wa.events.id(seq), "reset", []byte("{}"))
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		events, changed := wa.events.since(seq)
+		for _, e := range events {
+			seq = e.Seq
+			if withinPrefix(prefix, strings.TrimPrefix(e.Path, "/data/")) {
+				data, _ := json.Marshal(e)
+				writeEvent(w, wa.events.id(e.Seq), e.Event, data)
+			}
+		}
+		flusher.Flush()
+		select {
+		case <-changed:
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-wa.stop:
+			return
+		}
+	}
+}
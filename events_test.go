@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestChangeLog(t *testing.T) {
+	l := newChangeLog()
+	seq, found := l.position("")
+	assertTrue(t, "", found)
+	events, changed := l.since(seq)
+	assertEqualsInt(t, "", 0, len(events))
+
+	l.add("create", "app/game/1.json", []byte(`{}`))
+	l.add("delete", "app/game/", nil)
+	<-changed
+	events, _ = l.since(seq)
+	assertEqualsInt(t, "", 2, len(events))
+	assertEqualsStr(t, "", "created", events[0].Event)
+	assertEqualsStr(t, "", "/data/app/game/1", events[0].Path)
+	assertEqualsStr(t, "", etagOf([]byte(`{}`)), events[0].ETag)
+	assertEqualsStr(t, "", "deleted", events[1].Event)
+	assertEqualsStr(t, "", "/data/app/game/", events[1].Path)
+
+	// Resume after the first event
+	seq, found = l.position(l.id(events[0].Seq))
+	assertTrue(t, "", found)
+	events, _ = l.since(seq)
+	assertEqualsInt(t, "", 1, len(events))
+	assertEqualsStr(t, "", "deleted", events[0].Event)
+
+	// Unknown IDs
+	for _, id := range []string{"x", "x-1", l.id(3), l.boot + "-x"} {
+		_, found = l.position(id)
+		assertFalse(t, id, found)
+	}
+
+	// Too old IDs
+	for i := 0; i < maxChangeEvents; i++ {
+		l.add("update", fmt.Sprintf("app/%d.json", i), []byte(`1`))
+	}
+	_, found = l.position(l.id(1))
+	assertFalse(t, "Removed", found)
+	_, found = l.position(l.id(2))
+	assertTrue(t, "Oldest kept", found)
+}
+
+func TestWithinPrefix(t *testing.T) {
+	assertTrue(t, "", withinPrefix("", "app/obj"))
+	assertTrue(t, "", withinPrefix("app/game/", "app/game/1"))
+	assertTrue(t, "", withinPrefix("app/game/", "app/game/sub/1"))
+	assertTrue(t, "Deleted parent", withinPrefix("app/game/", "app/"))
+	assertFalse(t, "", withinPrefix("app/game/", "app/games/1"))
+	assertFalse(t, "", withinPrefix("app/game/", "app/other"))
+}
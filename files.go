@@ -1,10 +1,13 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
+	"io/fs"
 	"os"
 	"path"
+	"slices"
 	"strings"
+	"time"
 )
 
 // Lists files into a map of following structure:
@@ -32,6 +35,113 @@ func listFilesMap(dir string) (map[string][]string, error) {
 	return result, nil
 }
 
+// Removes hidden files and directories, i.e. names starting with .,
+// and the schema of the directory from a map created by listFilesMap.
+func removeHidden(filesMap map[string][]string) {
+	for key, names := range filesMap {
+		filesMap[key] = slices.DeleteFunc(names, func(name string) bool {
+			return strings.HasPrefix(name, ".") || (key == "files" && name == schemaFile)
+		})
+	}
+}
+
+// fileDetails is an object in a detailed listing
+type fileDetails struct {
+	Name  string    `json:"name"`  // Object name (without .json)
+	Size  int64     `json:"size"`  // Size in bytes
+	MTime time.Time `json:"mtime"` // Modification time
+	ETag  string    `json:"etag"`  // Same as the ETag of GET of the object
+
+	ModifiedBy string `json:"modifiedBy,omitempty"` // User who last modified the object
+}
+
+// dirDetails is a directory in a detailed listing
+type dirDetails struct {
+	Name     string    `json:"name"`     // Directory name
+	MTime    time.Time `json:"mtime"`    // Modification time
+	Children int       `json:"children"` // Number of objects and directories in the directory
+}
+
+// listDetails is a detailed listing of a directory
+type listDetails struct {
+	Dirs  []dirDetails  `json:"dirs"`
+	Files []fileDetails `json:"files"`
+}
+
+// Creates a detailed listing of the files and directories in a map
+// created by listFilesMapPage. Files for which skip returns true are not
+// included in the children count of the directories (skip may be nil).
+// The metadata of the files is read by meta (meta may be nil).
+func listFilesDetails(dir string, filesMap map[string][]string,
+	skip func(fullPath string, info fs.FileInfo) bool,
+	meta func(fullPath string) objectMeta) listDetails {
+	result := listDetails{Dirs: []dirDetails{}, Files: []fileDetails{}}
+	for _, name := range filesMap["dirs"] {
+		fullPath := path.Join(dir, name)
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			continue
+		}
+		children, _, err := listFilesMapPage(fullPath, nil, skip)
+		if err != nil {
+			continue
+		}
+		result.Dirs = append(result.Dirs, dirDetails{
+			Name:     name,
+			MTime:    info.ModTime().UTC(),
+			Children: len(children["files"]) + len(children["dirs"]),
+		})
+	}
+	for _, name := range filesMap["files"] {
+		fullPath := path.Join(dir, name)
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			continue
+		}
+		dat, err := os.ReadFile(fullPath)
+		if err != nil {
+			continue
+		}
+		details := fileDetails{
+			Name:  strings.TrimSuffix(name, ".json"),
+			Size:  info.Size(),
+			MTime: info.ModTime().UTC(),
+			ETag:  etagOf(dat),
+		}
+		if meta != nil {
+			details.ModifiedBy = meta(fullPath).ModifiedBy
+		}
+		result.Files = append(result.Files, details)
+	}
+	return result
+}
+
+// aggregateOptions controls how jsonOfJsons aggregates a directory
+type aggregateOptions struct {
+	depth  int                                          // Levels of subdirectories to include (-1 = all)
+	where  []condition                                  // Only objects fulfilling all conditions are included
+	page   *pageOptions                                 // Sorting and pagination (nil = all entries in name order)
+	fields [][]string                                   // Only these fields of the objects are included (nil = all)
+	skip   func(fullPath string, info fs.FileInfo) bool // Objects to leave out (nil = none)
+}
+
+// Checks if the contents of an object fulfills the where conditions
+func (opts aggregateOptions) matches(dat []byte) bool {
+	if len(opts.where) == 0 {
+		return true
+	}
+	doc, err := decodeJSON(dat)
+	if err != nil {
+		return false
+	}
+	for _, cond := range opts.where {
+		if !cond.matches(doc) {
+			return false
+		}
+	}
+	return true
+}
+
 // Gets all .json files and creates a new json including all
 // .json files. For example
 //
@@ -44,30 +154,255 @@ func listFilesMap(dir string) (map[string][]string, error) {
 //	  "filea" : {"a" : 1, "b" : 2},
 //	  "fileb" : [1,2,3,4]
 //	}
-func jsonOfJsons(dir string) (string, error) {
+//
+// If opts.depth is larger than 0 (or -1) subdirectories are included as
+// nested objects named after the subdirectory. A subdirectory with the
+// same name as a .json file is not included. Hidden subdirectories
+// (starting with .) are never included.
+//
+// If opts.where is set, only objects fulfilling all conditions are
+// included. The conditions are not applied on subdirectories, only on
+// the objects inside them.
+//
+// If opts.fields is set, only these fields of each object are included.
+func jsonOfJsons(dir string, opts aggregateOptions) (string, error) {
+	result, _, err := jsonOfJsonsPage(dir, opts)
+	return result, err
+}
+
+// Same as jsonOfJsons, but sorts and paginates the entries (objects and
+// subdirectories) directly in dir according to opts.page. Returns the
+// cursor to the next page as well ("" if there are no more entries).
+func jsonOfJsonsPage(dir string, opts aggregateOptions) (string, string, error) {
 	files, err := os.ReadDir(dir)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-	var result strings.Builder
-	isFirst := true // Flag for , between key : values
-	result.WriteString("{")
+	var entries []pageEntry
+	var values [][]byte
+	names := make(map[string]bool)
 	for _, file := range files {
-		if !file.IsDir() && path.Ext(file.Name()) == ".json" {
-			if !isFirst {
-				result.WriteString(",") // Add separator
-			}
-			result.WriteString("\n")
-			// Write key (file name without extension)
+		if !file.IsDir() && path.Ext(file.Name()) == ".json" && file.Name() != schemaFile {
 			name := strings.TrimSuffix(file.Name(), ".json")
-			result.WriteString(fmt.Sprintf(`"%s":`, name))
-			// Write value (file contents)
+			names[name] = true
 			fullPath := path.Join(dir, file.Name())
+			if opts.skip != nil {
+				info, err := file.Info()
+				if err != nil || opts.skip(fullPath, info) {
+					continue
+				}
+			}
 			dat, _ := os.ReadFile(fullPath)
-			result.Write(dat)
-			isFirst = false
+			if !opts.matches(dat) {
+				continue
+			}
+			entries = append(entries, opts.pageEntry(name, file, dat))
+			values = append(values, opts.project(dat))
+		}
+	}
+	if opts.depth != 0 {
+		subOpts := opts
+		subOpts.page = nil
+		if subOpts.depth > 0 {
+			subOpts.depth--
+		}
+		for _, file := range files {
+			name := file.Name()
+			if !file.IsDir() || strings.HasPrefix(name, ".") || names[name] {
+				continue
+			}
+			subDir, err := jsonOfJsons(path.Join(dir, name), subOpts)
+			if err != nil {
+				continue
+			}
+			entries = append(entries, opts.pageEntry(name, file, nil))
+			values = append(values, []byte(subDir))
+		}
+	}
+
+	indices := make([]int, len(entries))
+	for i := range indices {
+		indices[i] = i
+	}
+	next := ""
+	if opts.page != nil {
+		indices, next = opts.page.page(entries)
+	}
+
+	var result strings.Builder
+	result.WriteString("{")
+	for i, index := range indices {
+		if i > 0 {
+			result.WriteString(",") // Add separator
 		}
+		result.WriteString("\n")
+		// Write key (file name without extension)
+		keyJson, _ := json.Marshal(entries[index].name)
+		result.Write(keyJson)
+		result.WriteString(":")
+		// Write value (file contents)
+		result.Write(values[index])
 	}
 	result.WriteString("\n}")
-	return result.String(), nil
+	return result.String(), next, nil
+}
+
+// Projects the contents of an object to the fields in opts.fields
+func (opts aggregateOptions) project(dat []byte) []byte {
+	if opts.fields == nil {
+		return dat
+	}
+	doc, err := decodeJSON(dat)
+	if err != nil {
+		return dat
+	}
+	projected, err := encodeJSON(projectFields(doc, opts.fields))
+	if err != nil {
+		return dat
+	}
+	return projected
+}
+
+// Creates the page entry of an object or directory
+func (opts aggregateOptions) pageEntry(name string, file fs.DirEntry, dat []byte) pageEntry {
+	entry := pageEntry{name: name}
+	if opts.page != nil {
+		info, err := file.Info()
+		if err == nil {
+			entry.key = opts.page.key(info, dat)
+		}
+	}
+	return entry
+}
+
+// Returns the latest modification time of directory dir and of the
+// objects and directories in it. Subdirectories are included according
+// to depth (-1 = all levels). Hidden files and directories are ignored.
+// Objects that have expired, according to expiry (may be nil), are
+// considered modified when they expired.
+func latestModTime(dir string, depth int,
+	expiry func(fullPath string, info fs.FileInfo) time.Time) time.Time {
+	var latest time.Time
+	info, err := os.Stat(dir)
+	if err != nil {
+		return latest
+	}
+	latest = info.ModTime()
+	files, _ := os.ReadDir(dir)
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		var modTime time.Time
+		if file.IsDir() && depth != 0 {
+			modTime = latestModTime(path.Join(dir, file.Name()), max(depth-1, -1), expiry)
+		} else if info, err := file.Info(); err == nil {
+			modTime = info.ModTime()
+			if expiry != nil {
+				expires := expiry(path.Join(dir, file.Name()), info)
+				if !expires.IsZero() && time.Now().After(expires) && expires.After(modTime) {
+					modTime = expires
+				}
+			}
+		}
+		if modTime.After(latest) {
+			latest = modTime
+		}
+	}
+	return latest
+}
+
+// Same as listFilesMap but with hidden files and directories removed,
+// and with the entries sorted and paginated according to page. Files
+// and directories are sorted and paginated together. Returns the cursor
+// to the next page as well ("" if there are no more entries). Files for
+// which skip returns true are left out (skip may be nil).
+func listFilesMapPage(dir string, page *pageOptions,
+	skip func(fullPath string, info fs.FileInfo) bool) (map[string][]string, string, error) {
+	filesMap, err := listFilesMap(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	removeHidden(filesMap)
+	if skip != nil {
+		filesMap["files"] = slices.DeleteFunc(filesMap["files"], func(name string) bool {
+			fullPath := path.Join(dir, name)
+			info, err := os.Stat(fullPath)
+			return err != nil || skip(fullPath, info)
+		})
+	}
+	if page == nil {
+		return filesMap, "", nil
+	}
+	var entries []pageEntry
+	isDir := make(map[string]bool)
+	for _, key := range []string{"files", "dirs"} {
+		for _, name := range filesMap[key] {
+			isDir[name] = key == "dirs"
+			fullPath := path.Join(dir, name)
+			info, err := os.Stat(fullPath)
+			if err != nil {
+				continue
+			}
+			var dat []byte
+			if !info.IsDir() && page.sort != "name" && page.sort != "mtime" {
+				dat, _ = os.ReadFile(fullPath)
+			}
+			entries = append(entries, pageEntry{name: name, key: page.key(info, dat)})
+		}
+	}
+	indices, next := page.page(entries)
+	result := map[string][]string{
+		"files": {},
+		"dirs":  {},
+	}
+	for _, index := range indices {
+		name := entries[index].name
+		if isDir[name] {
+			result["dirs"] = append(result["dirs"], name)
+		} else {
+			result["files"] = append(result["files"], name)
+		}
+	}
+	return result, next, nil
+}
+
+// Writes data to a file in a crash safe way. The data is first written
+// to a temporary file in the same directory, which is synced to disk
+// and then renamed to name. A crash will thus either leave the old
+// file or the new file, never a truncated one.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	dir, base := path.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, name)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	// Sync the directory so that the rename itself is persisted. Not
+	// all platforms support syncing directories, thus errors are ignored.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
 }
@@ -2,8 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"io/fs"
+	"os"
+	"path"
 	"slices"
 	"testing"
+	"time"
 )
 
 func TestListFilesMap(t *testing.T) {
@@ -29,14 +33,43 @@ func TestListFilesMap(t *testing.T) {
 	assertExpectErr(t, "", err)
 }
 
+func TestListFilesDetails(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(path.Join(dir, "sub", "subsub"), 0777)
+	os.WriteFile(path.Join(dir, "obj.json"), []byte(`{"a":1}`), 0666)
+	os.WriteFile(path.Join(dir, "sub", "x.json"), []byte(`{}`), 0666)
+	os.WriteFile(path.Join(dir, "sub", "expired.json"), []byte(`{}`), 0666)
+	os.WriteFile(path.Join(dir, "sub", ".hidden.json"), []byte(`{}`), 0666)
+
+	m, _, err := listFilesMapPage(dir, nil, nil)
+	assertExpectNoErr(t, "", err)
+	skip := func(fullPath string, info fs.FileInfo) bool {
+		return path.Base(fullPath) == "expired.json"
+	}
+	details := listFilesDetails(dir, m, skip, nil)
+	assertEqualsInt(t, "", 1, len(details.Files))
+	assertEqualsStr(t, "", "obj", details.Files[0].Name)
+	assertEqualsInt(t, "", 7, int(details.Files[0].Size))
+	assertEqualsStr(t, "", etagOf([]byte(`{"a":1}`)), details.Files[0].ETag)
+	assertTrue(t, "", time.Since(details.Files[0].MTime) < time.Minute)
+	assertEqualsInt(t, "", 1, len(details.Dirs))
+	assertEqualsStr(t, "", "sub", details.Dirs[0].Name)
+	assertEqualsInt(t, "Children", 2, details.Dirs[0].Children)
+
+	// Empty directory
+	details = listFilesDetails(path.Join(dir, "sub", "subsub"), map[string][]string{}, nil, nil)
+	detailsJson, _ := json.Marshal(details)
+	assertEqualsStr(t, "", `{"dirs":[],"files":[]}`, string(detailsJson))
+}
+
 func TestJsonOfJsons(t *testing.T) {
 	// Check a directory without json files
-	res, err := jsonOfJsons(".")
+	res, err := jsonOfJsons(".", aggregateOptions{})
 	assertExpectNoErr(t, "", err)
 	assertEqualsStr(t, "", "{\n}", res)
 
 	// Check a directory with multiple jsons
-	res, err = jsonOfJsons(".test/data/adir")
+	res, err = jsonOfJsons(".test/data/adir", aggregateOptions{})
 	assertExpectNoErr(t, "", err)
 	var m map[string]interface{}
 	err = json.Unmarshal([]byte(res), &m)
@@ -54,6 +87,89 @@ func TestJsonOfJsons(t *testing.T) {
 	assertEqualsInt(t, "", 12, arr2)
 
 	// Try a directory that not exist
-	_, err = jsonOfJsons("non/existing")
+	_, err = jsonOfJsons("non/existing", aggregateOptions{})
+	assertExpectErr(t, "", err)
+}
+
+func TestJsonOfJsonsDepth(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(path.Join(dir, "sub/subsub"), 0777)
+	os.MkdirAll(path.Join(dir, "empty"), 0777)
+	os.MkdirAll(path.Join(dir, "both"), 0777)
+	os.MkdirAll(path.Join(dir, ".hidden"), 0777)
+	os.WriteFile(path.Join(dir, "a.json"), []byte(`1`), 0666)
+	os.WriteFile(path.Join(dir, "both.json"), []byte(`2`), 0666)
+	os.WriteFile(path.Join(dir, "both/x.json"), []byte(`3`), 0666)
+	os.WriteFile(path.Join(dir, "sub/b.json"), []byte(`{"b":4}`), 0666)
+	os.WriteFile(path.Join(dir, "sub/subsub/c.json"), []byte(`[5]`), 0666)
+	os.WriteFile(path.Join(dir, ".hidden/d.json"), []byte(`6`), 0666)
+
+	aggregate := func(depth int) map[string]interface{} {
+		res, err := jsonOfJsons(dir, aggregateOptions{depth: depth})
+		assertExpectNoErr(t, "", err)
+		var m map[string]interface{}
+		err = json.Unmarshal([]byte(res), &m)
+		assertExpectNoErr(t, res, err)
+		return m
+	}
+
+	// No subdirectories
+	m := aggregate(0)
+	assertEqualsInt(t, "", 2, len(m))
+
+	// One level of subdirectories. The file wins over a directory with
+	// the same name.
+	m = aggregate(1)
+	assertEqualsInt(t, "", 4, len(m))
+	assertEqualsInt(t, "", 2, int(m["both"].(float64)))
+	assertEqualsInt(t, "", 0, len(m["empty"].(map[string]interface{})))
+	sub := m["sub"].(map[string]interface{})
+	assertEqualsInt(t, "", 1, len(sub))
+	_, hasKey := sub["b"]
+	assertTrue(t, "", hasKey)
+
+	// All levels
+	m = aggregate(-1)
+	sub = m["sub"].(map[string]interface{})
+	subsub := sub["subsub"].(map[string]interface{})
+	assertEqualsInt(t, "", 5, int(subsub["c"].([]interface{})[0].(float64)))
+	_, hasKey = m[".hidden"]
+	assertFalse(t, "", hasKey)
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	tmpDir := t.TempDir()
+	name := path.Join(tmpDir, "atomic.json")
+
+	// Create a new file
+	err := writeFileAtomic(name, []byte(`{"a": 1}`), 0666)
+	assertExpectNoErr(t, "", err)
+	dat, err := os.ReadFile(name)
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", `{"a": 1}`, string(dat))
+
+	// Overwrite the file
+	err = writeFileAtomic(name, []byte(`[1,2]`), 0666)
+	assertExpectNoErr(t, "", err)
+	dat, err = os.ReadFile(name)
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", `[1,2]`, string(dat))
+
+	// No temporary files shall be left behind
+	entries, err := os.ReadDir(tmpDir)
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 1, len(entries))
+
+	// Writing onto a directory shall fail and leave no temporary file
+	dirName := path.Join(tmpDir, "adir.json")
+	os.Mkdir(dirName, 0777)
+	err = writeFileAtomic(dirName, []byte(`{}`), 0666)
+	assertExpectErr(t, "", err)
+	entries, err = os.ReadDir(tmpDir)
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 2, len(entries))
+
+	// Writing into a directory that don't exist shall fail
+	err = writeFileAtomic(path.Join(tmpDir, "no/dir/file.json"), []byte(`{}`), 0666)
 	assertExpectErr(t, "", err)
 }
@@ -1,3 +1,5 @@
 module github.com/midstar/waserver
 
 go 1.22
+
+require golang.org/x/crypto v0.9.0
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// history keeps previous revisions of data objects. The revisions of
+// object <dir>/<obj>.json are stored as <root>/<dir>/<obj>.json.revs/<rev>.json
+// where rev is a sequence number starting at 1. The last given rev is
+// kept in <root>/<dir>/<obj>.json.revs/last, so that the numbers are
+// never reused even if all revisions are removed. The suffix keeps the
+// revisions of object <obj> apart from the revisions of the objects in
+// directory <obj>. The modification time of a revision file is the time
+// when it was replaced or deleted.
+type history struct {
+	root     string        // Root directory of all revisions
+	maxCount int           // Max revisions per object (0 = disabled, -1 = unlimited)
+	maxAge   time.Duration // Max age of revisions (0 = unlimited)
+
+	// Called when the size of the revisions of object rel has changed
+	// by bytes (may be nil)
+	sizeChanged func(rel string, bytes int64)
+}
+
+// Suffix of the directories of the revisions of an object
+const revsSuffix = ".revs"
+
+// Name of the file keeping the last revision number of an object
+const lastRevFile = "last"
+
+// Interval of removing revisions older than maxAge of all objects,
+// including deleted objects
+const historyPruneInterval = 10 * time.Minute
+
+// revision describes one revision of an object
+type revision struct {
+	Rev  int       `json:"rev"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+func newHistory(root string, maxCount int, maxAge time.Duration) *history {
+	return &history{
+		root:     root,
+		maxCount: maxCount,
+		maxAge:   maxAge,
+	}
+}
+
+// Returns the directory of the revisions of object rel (for example
+// adir/obj.json)
+func (h *history) revDir(rel string) string {
+	return path.Join(h.root, rel+revsSuffix)
+}
+
+// Stores data as a new revision of object rel and removes revisions
+// that are no longer to be retained.
+func (h *history) archive(rel string, data []byte) error {
+	if h.maxCount == 0 {
+		return nil
+	}
+	next := max(h.lastRev(rel), h.latest(rel)) + 1
+	dir := h.revDir(rel)
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(path.Join(dir, fmt.Sprintf("%d.json", next)), data, 0666)
+	if err != nil {
+		return err
+	}
+	h.changed(rel, int64(len(data)))
+	err = writeFileAtomic(path.Join(dir, lastRevFile), []byte(strconv.Itoa(next)), 0666)
+	if err != nil {
+		return err
+	}
+	return h.prune(rel)
+}
+
+// Returns the last revision number given to object rel (0 if none)
+func (h *history) lastRev(rel string) int {
+	dat, err := os.ReadFile(path.Join(h.revDir(rel), lastRevFile))
+	if err != nil {
+		return 0
+	}
+	rev, _ := strconv.Atoi(string(dat))
+	return rev
+}
+
+func (h *history) changed(rel string, bytes int64) {
+	if h.sizeChanged != nil {
+		h.sizeChanged(rel, bytes)
+	}
+}
+
+// Lists all revisions of object rel, oldest first. An object without
+// revisions returns an empty list.
+func (h *history) list(rel string) ([]revision, error) {
+	entries, err := os.ReadDir(h.revDir(rel))
+	if os.IsNotExist(err) {
+		return []revision{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	revs := []revision{}
+	for _, entry := range entries {
+		rev, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".json"))
+		if entry.IsDir() || path.Ext(entry.Name()) != ".json" || err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		revs = append(revs, revision{Rev: rev, Time: info.ModTime(), Size: info.Size()})
+	}
+	slices.SortFunc(revs, func(a, b revision) int { return a.Rev - b.Rev })
+	return revs, nil
+}
+
+// Returns the latest revision of object rel (0 if there is none)
+func (h *history) latest(rel string) int {
+	revs, err := h.list(rel)
+	if err != nil || len(revs) == 0 {
+		return 0
+	}
+	return revs[len(revs)-1].Rev
+}
+
+// Removes the revisions of object rel after revision rev
+func (h *history) removeAfter(rel string, rev int) {
+	revs, _ := h.list(rel)
+	for _, r := range revs {
+		if r.Rev > rev && os.Remove(path.Join(h.revDir(rel), fmt.Sprintf("%d.json", r.Rev))) == nil {
+			h.changed(rel, -r.Size)
+		}
+	}
+}
+
+// Reads revision rev of object rel
+func (h *history) read(rel string, rev int) ([]byte, error) {
+	return os.ReadFile(path.Join(h.revDir(rel), fmt.Sprintf("%d.json", rev)))
+}
+
+// Removes revisions of object rel exceeding maxCount or maxAge
+func (h *history) prune(rel string) error {
+	revs, err := h.list(rel)
+	if err != nil {
+		return err
+	}
+	dir := h.revDir(rel)
+	for i, rev := range revs {
+		tooMany := h.maxCount > 0 && len(revs)-i > h.maxCount
+		tooOld := h.maxAge > 0 && time.Since(rev.Time) > h.maxAge
+		if tooMany || tooOld {
+			if os.Remove(path.Join(dir, fmt.Sprintf("%d.json", rev.Rev))) == nil {
+				h.changed(rel, -rev.Size)
+			}
+		}
+	}
+	return nil
+}
+
+// Removes revisions older than maxAge of all objects. Revisions are
+// otherwise only removed when the object is modified again, thus the
+// revisions of deleted objects would be kept forever. The last revision
+// numbers are kept, so that the numbers are not reused if the object is
+// written again.
+func (h *history) pruneAll() {
+	if h.maxAge <= 0 {
+		return
+	}
+	filepath.WalkDir(h.root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || path.Ext(name) != ".json" || time.Since(info.ModTime()) <= h.maxAge {
+			return nil
+		}
+		if os.Remove(name) == nil {
+			rel, _ := filepath.Rel(h.root, name)
+			h.changed(filepath.ToSlash(rel), -info.Size())
+		}
+		return nil
+	})
+}
+
+// Removes the oldest revisions of the objects in directory relDir
+// (including subdirectories) until at least bytes have been removed.
+// Returns the number of removed bytes.
+func (h *history) purge(relDir string, bytes int64) int64 {
+	type revFile struct {
+		name    string
+		modTime time.Time
+		size    int64
+	}
+	var revs []revFile
+	filepath.WalkDir(path.Join(h.root, relDir), func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(name) != ".json" {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			revs = append(revs, revFile{name, info.ModTime(), info.Size()})
+		}
+		return nil
+	})
+	slices.SortFunc(revs, func(a, b revFile) int { return a.modTime.Compare(b.modTime) })
+	var removed int64
+	for _, rev := range revs {
+		if removed >= bytes {
+			break
+		}
+		if os.Remove(rev.name) == nil {
+			removed += rev.size
+		}
+	}
+	h.changed(relDir+"/", -removed)
+	return removed
+}
+
+// Archives all objects inside directory fullDir, which is relDir
+// relative to the data directory. Used before a directory is deleted.
+func (h *history) archiveDir(fullDir string, relDir string) error {
+	return filepath.WalkDir(fullDir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && name != fullDir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || path.Ext(d.Name()) != ".json" {
+			return nil
+		}
+		relName, err := filepath.Rel(fullDir, name)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		return h.archive(path.Join(relDir, filepath.ToSlash(relName)), data)
+	})
+}
+
+// Runs history.pruneAll periodically until stop is closed
+func (wa *WebAPI) runHistoryPruner(stop chan struct{}) {
+	if wa.history.maxAge <= 0 {
+		return
+	}
+	ticker := time.NewTicker(historyPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			wa.mutex.Lock()
+			wa.history.pruneAll()
+			wa.mutex.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
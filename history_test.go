@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestHistoryArchive(t *testing.T) {
+	h := newHistory(t.TempDir(), 3, 0)
+
+	// No revisions
+	revs, err := h.list("adir/obj.json")
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 0, len(revs))
+
+	// Add revisions
+	for i := 1; i <= 5; i++ {
+		err = h.archive("adir/obj.json", []byte(`{"rev": `+string(rune('0'+i))+`}`))
+		assertExpectNoErr(t, "", err)
+	}
+
+	// Only the three latest shall be kept
+	revs, err = h.list("adir/obj.json")
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 3, len(revs))
+	assertEqualsInt(t, "", 3, revs[0].Rev)
+	assertEqualsInt(t, "", 5, revs[2].Rev)
+	assertEqualsInt(t, "", 10, int(revs[2].Size))
+
+	dat, err := h.read("adir/obj.json", 4)
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", `{"rev": 4}`, string(dat))
+
+	_, err = h.read("adir/obj.json", 1)
+	assertExpectErr(t, "", err)
+
+	// Other objects are not affected
+	revs, err = h.list("adir/other.json")
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 0, len(revs))
+}
+
+func TestHistoryMaxAge(t *testing.T) {
+	h := newHistory(t.TempDir(), -1, time.Hour)
+
+	err := h.archive("obj.json", []byte(`1`))
+	assertExpectNoErr(t, "", err)
+
+	// Make the first revision old
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(path.Join(h.revDir("obj.json"), "1.json"), old, old)
+
+	err = h.archive("obj.json", []byte(`2`))
+	assertExpectNoErr(t, "", err)
+	revs, err := h.list("obj.json")
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 1, len(revs))
+	assertEqualsInt(t, "", 2, revs[0].Rev)
+}
+
+func TestHistoryNumbersNotReused(t *testing.T) {
+	h := newHistory(t.TempDir(), 1, 0)
+	h.archive("app/obj.json", []byte(`1`))
+	h.archive("app/obj.json", []byte(`2`))
+
+	// All revisions removed
+	h.removeAfter("app/obj.json", 0)
+	revs, _ := h.list("app/obj.json")
+	assertEqualsInt(t, "", 0, len(revs))
+
+	h.archive("app/obj.json", []byte(`3`))
+	revs, _ = h.list("app/obj.json")
+	assertEqualsInt(t, "", 1, len(revs))
+	assertEqualsInt(t, "", 3, revs[0].Rev)
+}
+
+func TestHistoryPruneAll(t *testing.T) {
+	h := newHistory(t.TempDir(), -1, time.Hour)
+	var removed int64
+	h.sizeChanged = func(rel string, bytes int64) {
+		if bytes < 0 {
+			removed -= bytes
+		}
+	}
+	h.archive("app/deleted.json", []byte(`1`))
+	h.archive("app/sub/deleted.json", []byte(`22`))
+	h.archive("app/kept.json", []byte(`1`))
+	h.archive("app/kept.json", []byte(`2`))
+
+	// Revisions of objects that are never written again
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(path.Join(h.revDir("app/deleted.json"), "1.json"), old, old)
+	os.Chtimes(path.Join(h.revDir("app/sub/deleted.json"), "1.json"), old, old)
+	os.Chtimes(path.Join(h.revDir("app/kept.json"), "1.json"), old, old)
+	h.pruneAll()
+
+	assertEqualsInt(t, "", 4, int(removed))
+	revs, _ := h.list("app/deleted.json")
+	assertEqualsInt(t, "", 0, len(revs))
+	revs, _ = h.list("app/sub/deleted.json")
+	assertEqualsInt(t, "", 0, len(revs))
+	revs, _ = h.list("app/kept.json")
+	assertEqualsInt(t, "", 1, len(revs))
+	assertEqualsInt(t, "", 2, revs[0].Rev)
+}
+
+func TestHistoryObjectAndDirectory(t *testing.T) {
+	h := newHistory(t.TempDir(), -1, 0)
+
+	// Object app.json and object x.json in directory app/
+	h.archive("app.json", []byte(`"object"`))
+	h.archive("app/x.json", []byte(`"in directory"`))
+	h.archive("app/1.json", []byte(`"one"`))
+
+	revs, _ := h.list("app.json")
+	assertEqualsInt(t, "", 1, len(revs))
+	dat, _ := h.read("app.json", 1)
+	assertEqualsStr(t, "", `"object"`, string(dat))
+	revs, _ = h.list("app/x.json")
+	assertEqualsInt(t, "", 1, len(revs))
+	dat, _ = h.read("app/x.json", 1)
+	assertEqualsStr(t, "", `"in directory"`, string(dat))
+
+	// Removing the history of directory app/ keeps the object app.json
+	h.purge("app", 1000)
+	revs, _ = h.list("app/x.json")
+	assertEqualsInt(t, "", 0, len(revs))
+	revs, _ = h.list("app.json")
+	assertEqualsInt(t, "", 1, len(revs))
+}
+
+func TestHistoryDisabled(t *testing.T) {
+	h := newHistory(t.TempDir(), 0, 0)
+	err := h.archive("obj.json", []byte(`1`))
+	assertExpectNoErr(t, "", err)
+	revs, err := h.list("obj.json")
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 0, len(revs))
+}
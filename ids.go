@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// idGenerator generates unique object IDs which are sortable on creation
+// time. An ID consists of 12 hex digits of milliseconds since the epoch
+// followed by 8 hex digits of a sequence number, which starts at a random
+// number each millisecond.
+type idGenerator struct {
+	mutex    sync.Mutex
+	lastTime int64  // Milliseconds of the last generated ID
+	sequence uint32 // Sequence number of the last generated ID
+}
+
+// Generates a new ID, which is always larger than the previous ID
+func (g *idGenerator) next() string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	now := time.Now().UnixMilli()
+	if now > g.lastTime {
+		g.lastTime = now
+		// Leave room for many IDs within the same millisecond
+		g.sequence = rand.Uint32() >> 1
+	} else {
+		g.sequence++
+		if g.sequence == 0 {
+			// Sequence wrapped, borrow from next millisecond
+			g.lastTime++
+		}
+	}
+	return fmt.Sprintf("%012x%08x", g.lastTime, g.sequence)
+}
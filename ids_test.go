@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestIdGenerator(t *testing.T) {
+	g := &idGenerator{}
+	previous := g.next()
+	assertEqualsInt(t, "", 20, len(previous))
+	for i := 0; i < 10000; i++ {
+		id := g.next()
+		assertTrue(t, id+" <= "+previous, id > previous)
+		previous = id
+	}
+
+	// Sequence wrap
+	g.sequence = 0xffffffff
+	lastTime := g.lastTime
+	id := g.next()
+	assertTrue(t, id+" <= "+previous, id > previous)
+	assertEqualsInt(t, "", int(lastTime+1), int(g.lastTime))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+)
+
+// Media types of the supported patch formats
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// Applies patch, of the media type given by contentType, to the current
+// contents of an object. exists is false if the object don't exist.
+// Returns the patched contents.
+func applyPatch(contentType string, current []byte, exists bool, patch []byte) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	patchDoc, err := decodeJSON(patch)
+	if err != nil {
+		return nil, &statusError{http.StatusBadRequest, err.Error()}
+	}
+	var target interface{}
+	if exists {
+		target, err = decodeJSON(current)
+		if err != nil {
+			return nil, &statusError{http.StatusInternalServerError, err.Error()}
+		}
+	}
+	switch mediaType {
+	case mergePatchType:
+		target = mergePatch(target, patchDoc)
+	case jsonPatchType:
+		target, err = jsonPatch(target, patchDoc)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, &statusError{http.StatusUnsupportedMediaType,
+			"Unsupported patch type: " + contentType}
+	}
+	return encodeJSON(target)
+}
+
+// Applies a JSON Merge Patch according to RFC 7386
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObj, isObj := patch.(map[string]interface{})
+	if !isObj {
+		return patch
+	}
+	targetObj, isObj := target.(map[string]interface{})
+	if !isObj {
+		targetObj = map[string]interface{}{}
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+		} else {
+			targetObj[key] = mergePatch(targetObj[key], value)
+		}
+	}
+	return targetObj
+}
+
+// Applies a JSON Patch according to RFC 6902. The operations are applied
+// in order and if any operation fails (including test operations) an
+// error is returned. Errors in the patch document itself returns
+// 400 Bad Request and operations that can't be applied on target
+// returns 409 Conflict.
+func jsonPatch(target interface{}, patch interface{}) (interface{}, error) {
+	ops, isArray := patch.([]interface{})
+	if !isArray {
+		return nil, &statusError{http.StatusBadRequest, "JSON patch must be an array"}
+	}
+	for i, opValue := range ops {
+		op, isObj := opValue.(map[string]interface{})
+		if !isObj {
+			return nil, &statusError{http.StatusBadRequest,
+				fmt.Sprintf("JSON patch operation %d is not an object", i)}
+		}
+		var err error
+		target, err = jsonPatchOperation(target, op)
+		if err != nil {
+			var statusErr *statusError
+			if errors.As(err, &statusErr) {
+				statusErr.message = fmt.Sprintf("JSON patch operation %d: %s", i, statusErr.message)
+				return nil, statusErr
+			}
+			return nil, &statusError{http.StatusConflict,
+				fmt.Sprintf("JSON patch operation %d: %s", i, err)}
+		}
+	}
+	return target, nil
+}
+
+// Applies one JSON Patch operation
+func jsonPatchOperation(target interface{}, op map[string]interface{}) (interface{}, error) {
+	name, _ := op["op"].(string)
+	tokens, err := jsonPatchPointer(op, "path")
+	if err != nil {
+		return nil, err
+	}
+	value, hasValue := op["value"]
+	if !hasValue && (name == "add" || name == "replace" || name == "test") {
+		return nil, &statusError{http.StatusBadRequest, "value missing"}
+	}
+	switch name {
+	case "add":
+		return pointerAdd(target, tokens, value)
+	case "remove":
+		target, _, err = pointerRemove(target, tokens)
+		return target, err
+	case "replace":
+		return pointerReplace(target, tokens, value)
+	case "move", "copy":
+		fromTokens, err := jsonPatchPointer(op, "from")
+		if err != nil {
+			return nil, err
+		}
+		if name == "move" {
+			if slices.Equal(tokens, fromTokens) {
+				// Moving a value to its own location changes nothing
+				_, err = pointerGet(target, fromTokens)
+				return target, err
+			}
+			if len(tokens) > len(fromTokens) && slices.Equal(tokens[:len(fromTokens)], fromTokens) {
+				return nil, &statusError{http.StatusConflict,
+					"can't move a value into one of its children"}
+			}
+			target, value, err = pointerRemove(target, fromTokens)
+		} else {
+			value, err = pointerGet(target, fromTokens)
+			value = jsonCopy(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(target, tokens, value)
+	case "test":
+		current, err := pointerGet(target, tokens)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(current, value) {
+			return nil, &statusError{http.StatusConflict, "test failed"}
+		}
+		return target, nil
+	}
+	return nil, &statusError{http.StatusBadRequest, fmt.Sprintf("invalid op %q", name)}
+}
+
+// Parses the JSON pointer member (path or from) of a JSON Patch operation
+func jsonPatchPointer(op map[string]interface{}, member string) ([]string, error) {
+	ptr, isString := op[member].(string)
+	if !isString {
+		return nil, &statusError{http.StatusBadRequest, member + " missing"}
+	}
+	tokens, err := parsePointer(ptr)
+	if err != nil {
+		return nil, &statusError{http.StatusBadRequest, err.Error()}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// Applies a patch and compares the result with expected JSON
+func assertPatch(t *testing.T, contentType string, current string, patch string, expected string) {
+	t.Helper()
+	result, err := applyPatch(contentType, []byte(current), current != "", []byte(patch))
+	assertExpectNoErr(t, "", err)
+	expectedValue, _ := decodeJSON([]byte(expected))
+	expectedJSON, _ := encodeJSON(expectedValue)
+	assertEqualsStr(t, "", string(expectedJSON), string(result))
+}
+
+// Applies a patch and checks that it fails with expected status
+func assertPatchFails(t *testing.T, contentType string, current string, patch string, status int) {
+	t.Helper()
+	_, err := applyPatch(contentType, []byte(current), current != "", []byte(patch))
+	assertExpectErr(t, "", err)
+	statusErr, isStatusErr := err.(*statusError)
+	assertTrue(t, "Not a status error: "+err.Error(), isStatusErr)
+	assertEqualsInt(t, statusErr.message, status, statusErr.status)
+}
+
+func TestMergePatch(t *testing.T) {
+	// Test cases from RFC 7386 appendix A
+	cases := [][3]string{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, c := range cases {
+		assertPatch(t, mergePatchType, c[0], c[1], c[2])
+	}
+
+	// Patching an object that don't exist
+	assertPatch(t, mergePatchType, "", `{"a":1,"b":null}`, `{"a":1}`)
+
+	// Numbers are kept unchanged
+	assertPatch(t, mergePatchType+"; charset=utf-8", `{"a":1.50}`, `{"b":12345678901234567890}`,
+		`{"a":1.50,"b":12345678901234567890}`)
+}
+
+func TestJSONPatch(t *testing.T) {
+	// Test cases from RFC 6902 appendix A
+	cases := [][3]string{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			`{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			`{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			`{"foo":["all","cows","eat","grass"]}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			`{"foo":"bar","child":{"grandchild":{}}}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/foo","value":"bar","xyz":123}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			`{"foo":["bar",["abc","def"]]}`},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+		{`{"foo":{"a":1}}`, `[{"op":"copy","from":"/foo","path":"/bar"},
+			{"op":"replace","path":"/bar/a","value":2}]`, `{"foo":{"a":1},"bar":{"a":2}}`},
+		{`{"a":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`},
+		{`{"a":null}`, `[{"op":"test","path":"/a","value":null}]`, `{"a":null}`},
+		{`{"a":1}`, `[{"op":"move","from":"","path":""}]`, `{"a":1}`},
+		{`{"a":1}`, `[{"op":"move","from":"/a","path":"/a"}]`, `{"a":1}`},
+	}
+	for _, c := range cases {
+		assertPatch(t, jsonPatchType, c[0], c[1], c[2])
+	}
+
+	// Patching an object that don't exist
+	assertPatch(t, jsonPatchType, "", `[{"op":"add","path":"","value":{"a":1}}]`, `{"a":1}`)
+
+	// Operations that fails to apply
+	failing := [][2]string{
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`},
+		{`{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`},
+		{`{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":1}]`},
+		{`{"foo":[1]}`, `[{"op":"add","path":"/foo/2","value":1}]`},
+		{`{"foo":{"a":1}}`, `[{"op":"move","from":"/foo","path":"/foo/a/b"}]`},
+		{`{"foo":{"a":1}}`, `[{"op":"copy","from":"/bar","path":"/baz"}]`},
+		{`{"foo":"bar"}`, `[{"op":"remove","path":""}]`},
+		{`{"foo":"bar"}`, `[{"op":"move","from":"","path":"/baz"}]`},
+		{`{"turn":"x"}`, `[{"op":"replace","path":"/turn","value":"o"},
+			{"op":"test","path":"/turn","value":"x"}]`},
+	}
+	for _, c := range failing {
+		assertPatchFails(t, jsonPatchType, c[0], c[1], http.StatusConflict)
+	}
+
+	// Invalid patch documents
+	invalid := []string{
+		`{"op":"add","path":"/a","value":1}`,
+		`[1]`,
+		`[{"op":"add","path":"/a"}]`,
+		`[{"op":"add","value":1}]`,
+		`[{"op":"add","path":"a","value":1}]`,
+		`[{"op":"move","path":"/a"}]`,
+		`[{"op":"invalid","path":"/a"}]`,
+	}
+	for _, patch := range invalid {
+		assertPatchFails(t, jsonPatchType, `{"a":1}`, patch, http.StatusBadRequest)
+	}
+}
+
+func TestApplyPatchErrors(t *testing.T) {
+	assertPatchFails(t, "text/plain", `{}`, `{}`, http.StatusUnsupportedMediaType)
+	assertPatchFails(t, mergePatchType, `{}`, `{`, http.StatusBadRequest)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errPointerTarget is returned (wrapped) when a JSON Pointer don't
+// resolve to a value in the document
+var errPointerTarget = errors.New("pointer target not found")
+
+// Splits an RFC 6901 JSON Pointer, such as /clubs/3/distance, into its
+// unescaped reference tokens. The empty pointer refers to the whole
+// document and returns no tokens.
+func parsePointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q: must start with /", ptr)
+	}
+	tokens := strings.Split(ptr[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// Parses token as an index into array. If allowEnd is true the index
+// may be equal to the length of the array, which is also the case for
+// the "-" token.
+func pointerIndex(array []interface{}, token string, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return len(array), nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", errPointerTarget, token)
+	}
+	if index > len(array) || (index == len(array) && !allowEnd) {
+		return 0, fmt.Errorf("%w: array index %d out of range", errPointerTarget, index)
+	}
+	return index, nil
+}
+
+// Returns the child token of container doc
+func pointerChild(doc interface{}, token string) (interface{}, error) {
+	switch container := doc.(type) {
+	case map[string]interface{}:
+		child, exists := container[token]
+		if !exists {
+			return nil, fmt.Errorf("%w: member %q don't exist", errPointerTarget, token)
+		}
+		return child, nil
+	case []interface{}:
+		index, err := pointerIndex(container, token, false)
+		if err != nil {
+			return nil, err
+		}
+		return container[index], nil
+	}
+	return nil, fmt.Errorf("%w: %q is not inside an object or array", errPointerTarget, token)
+}
+
+// Returns the value which tokens refers to
+func pointerGet(doc interface{}, tokens []string) (interface{}, error) {
+	for _, token := range tokens {
+		var err error
+		doc, err = pointerChild(doc, token)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// Walks to the container holding the last token and calls update with
+// the container and the last token. update returns the (possibly new)
+// container, which replaces the old container in the document. Returns
+// the updated document. tokens must not be empty.
+func pointerUpdate(doc interface{}, tokens []string,
+	update func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return update(doc, tokens[0])
+	}
+	child, err := pointerChild(doc, tokens[0])
+	if err != nil {
+		return nil, err
+	}
+	child, err = pointerUpdate(child, tokens[1:], update)
+	if err != nil {
+		return nil, err
+	}
+	switch container := doc.(type) {
+	case map[string]interface{}:
+		container[tokens[0]] = child
+	case []interface{}:
+		index, _ := pointerIndex(container, tokens[0], false)
+		container[index] = child
+	}
+	return doc, nil
+}
+
+// Adds value at tokens according to the RFC 6902 add operation. An
+// existing object member is replaced and array elements are inserted.
+func pointerAdd(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return pointerUpdate(doc, tokens, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[token] = value
+			return c, nil
+		case []interface{}:
+			index, err := pointerIndex(c, token, true)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:index], append([]interface{}{value}, c[index:]...)...), nil
+		}
+		return nil, fmt.Errorf("%w: %q is not inside an object or array", errPointerTarget, token)
+	})
+}
+
+// Removes the value at tokens. Returns the updated document and the
+// removed value. The whole document can't be removed.
+func pointerRemove(doc interface{}, tokens []string) (interface{}, interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, nil, errors.New("the whole document can't be removed")
+	}
+	var removed interface{}
+	doc, err := pointerUpdate(doc, tokens, func(container interface{}, token string) (interface{}, error) {
+		var err error
+		removed, err = pointerChild(container, token)
+		if err != nil {
+			return nil, err
+		}
+		switch c := container.(type) {
+		case map[string]interface{}:
+			delete(c, token)
+			return c, nil
+		case []interface{}:
+			index, _ := pointerIndex(c, token, false)
+			return append(c[:index], c[index+1:]...), nil
+		}
+		return container, nil
+	})
+	return doc, removed, err
+}
+
+// Replaces the existing value at tokens
+func pointerReplace(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return pointerUpdate(doc, tokens, func(container interface{}, token string) (interface{}, error) {
+		_, err := pointerChild(container, token)
+		if err != nil {
+			return nil, err
+		}
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[token] = value
+		case []interface{}:
+			index, _ := pointerIndex(c, token, false)
+			c[index] = value
+		}
+		return container, nil
+	})
+}
+
+// Creates a deep copy of a value created by decodeJSON
+func jsonCopy(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			result[key] = jsonCopy(child)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, child := range value {
+			result[i] = jsonCopy(child)
+		}
+		return result
+	}
+	return v
+}
+
+// Compares two values created by decodeJSON. Numbers are compared by
+// value, thus 1, 1.0 and 1e0 are equal.
+func jsonEqual(a, b interface{}) bool {
+	switch va := a.(type) {
+	case map[string]interface{}:
+		vb, isObj := b.(map[string]interface{})
+		if !isObj || len(va) != len(vb) {
+			return false
+		}
+		for key, child := range va {
+			childB, exists := vb[key]
+			if !exists || !jsonEqual(child, childB) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		vb, isArray := b.([]interface{})
+		if !isArray || len(va) != len(vb) {
+			return false
+		}
+		for i := range va {
+			if !jsonEqual(va[i], vb[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		vb, isNumber := b.(json.Number)
+		if !isNumber {
+			return false
+		}
+		cmp, ok := compareNumbers(string(va), string(vb))
+		return ok && cmp == 0
+	}
+	return a == b
+}
+
+// Converts an error from the pointer functions to a statusError. A
+// pointer that don't resolve gives 404 Not Found.
+func pointerStatusError(err error) error {
+	if errors.Is(err, errPointerTarget) {
+		return &statusError{http.StatusNotFound, err.Error()}
+	}
+	return &statusError{http.StatusBadRequest, err.Error()}
+}
+
+// Returns the value at JSON pointer ptr in the JSON document data
+func getAtPointer(data []byte, ptr string) ([]byte, error) {
+	tokens, err := parsePointer(ptr)
+	if err != nil {
+		return nil, pointerStatusError(err)
+	}
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	value, err := pointerGet(doc, tokens)
+	if err != nil {
+		return nil, pointerStatusError(err)
+	}
+	return encodeJSON(value)
+}
+
+// Sets the value at JSON pointer ptr in the JSON document data. An
+// existing value is replaced, otherwise the value is added to the
+// parent object or array, which must exist.
+func setAtPointer(data []byte, ptr string, value []byte) ([]byte, error) {
+	tokens, err := parsePointer(ptr)
+	if err != nil {
+		return nil, pointerStatusError(err)
+	}
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	v, err := decodeJSON(value)
+	if err != nil {
+		return nil, &statusError{http.StatusBadRequest, err.Error()}
+	}
+	if _, err = pointerGet(doc, tokens); err == nil {
+		doc, err = pointerReplace(doc, tokens, v)
+	} else {
+		doc, err = pointerAdd(doc, tokens, v)
+	}
+	if err != nil {
+		return nil, pointerStatusError(err)
+	}
+	return encodeJSON(doc)
+}
+
+// Removes the value at JSON pointer ptr in the JSON document data
+func removeAtPointer(data []byte, ptr string) ([]byte, error) {
+	tokens, err := parsePointer(ptr)
+	if err != nil {
+		return nil, pointerStatusError(err)
+	}
+	if len(tokens) == 0 {
+		return nil, &statusError{http.StatusBadRequest, "Pointer to whole document not allowed"}
+	}
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	doc, _, err = pointerRemove(doc, tokens)
+	if err != nil {
+		return nil, pointerStatusError(err)
+	}
+	return encodeJSON(doc)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParsePointer(t *testing.T) {
+	tokens, err := parsePointer("")
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 0, len(tokens))
+
+	tokens, err = parsePointer("/clubs/3/distance")
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 3, len(tokens))
+	assertEqualsStr(t, "", "3", tokens[1])
+
+	tokens, err = parsePointer("/a~1b/m~0n/")
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 3, len(tokens))
+	assertEqualsStr(t, "", "a/b", tokens[0])
+	assertEqualsStr(t, "", "m~n", tokens[1])
+	assertEqualsStr(t, "", "", tokens[2])
+
+	_, err = parsePointer("clubs")
+	assertExpectErr(t, "", err)
+}
+
+func TestPointerGet(t *testing.T) {
+	doc, _ := decodeJSON([]byte(`{"clubs": [{"distance": 100}, {"distance": 150}], "": 1}`))
+
+	get := func(ptr string) (interface{}, error) {
+		tokens, err := parsePointer(ptr)
+		assertExpectNoErr(t, "", err)
+		return pointerGet(doc, tokens)
+	}
+
+	value, err := get("/clubs/1/distance")
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", "150", string(value.(json.Number)))
+
+	value, err = get("/")
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", "1", string(value.(json.Number)))
+
+	value, err = get("")
+	assertExpectNoErr(t, "", err)
+	assertTrue(t, "", jsonEqual(doc, value))
+
+	for _, ptr := range []string{"/clubs/2", "/clubs/01", "/clubs/-", "/clubs/x",
+		"/nothing", "/clubs/0/distance/x"} {
+		_, err = get(ptr)
+		assertTrue(t, ptr, errors.Is(err, errPointerTarget))
+	}
+}
+
+func TestJsonEqual(t *testing.T) {
+	equal := func(a, b string) bool {
+		va, _ := decodeJSON([]byte(a))
+		vb, _ := decodeJSON([]byte(b))
+		return jsonEqual(va, vb)
+	}
+	assertTrue(t, "", equal(`1`, `1.0`))
+	assertTrue(t, "", equal(`1000`, `1e3`))
+	assertTrue(t, "", equal(`{"a":[1,"b",null,true]}`, `{"a":[1,"b",null,true]}`))
+	assertFalse(t, "", equal(`{"a":1}`, `{"a":1,"b":2}`))
+	assertFalse(t, "", equal(`[1,2]`, `[2,1]`))
+	assertFalse(t, "", equal(`"1"`, `1`))
+	assertFalse(t, "", equal(`null`, `false`))
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Validates that data is exactly one JSON value not exceeding the
+// nesting depth maxDepth and with no strings (or keys) longer than
+// maxStringLength bytes. The returned error includes the line and
+// column of the problem.
+func validateJSON(data []byte, maxDepth int, maxStringLength int) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	depth := 0
+	values := 0
+	for {
+		offset := dec.InputOffset()
+		token, err := dec.Token()
+		if err == io.EOF {
+			if depth > 0 {
+				return jsonErrorAt(data, int64(len(data)), "unexpected end of JSON input")
+			}
+			break
+		}
+		if err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				offset = syntaxErr.Offset
+			} else {
+				offset = int64(len(data))
+			}
+			return jsonErrorAt(data, offset, err.Error())
+		}
+		if depth == 0 {
+			values++
+			if values > 1 {
+				return jsonErrorAt(data, offset, "unexpected data after JSON value")
+			}
+		}
+		switch v := token.(type) {
+		case json.Delim:
+			if v == '{' || v == '[' {
+				depth++
+				if depth > maxDepth {
+					return jsonErrorAt(data, offset,
+						fmt.Sprintf("nesting depth exceeds %d", maxDepth))
+				}
+			} else {
+				depth--
+			}
+		case string:
+			if len(v) > maxStringLength {
+				return jsonErrorAt(data, offset,
+					fmt.Sprintf("string length exceeds %d", maxStringLength))
+			}
+		}
+	}
+	if values == 0 {
+		return errors.New("invalid JSON: no data")
+	}
+	return nil
+}
+
+// Creates an error including line and column (both starting at 1) of
+// the byte offset in data.
+func jsonErrorAt(data []byte, offset int64, message string) error {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line := 1
+	column := 1
+	for _, b := range data[:offset] {
+		if b == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return fmt.Errorf("invalid JSON at line %d, column %d: %s", line, column, message)
+}
+
+// Decodes JSON data into maps, slices and basic types. Numbers are
+// decoded as json.Number so that they are written back unchanged.
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Encodes a value created by decodeJSON. In contrast to json.Marshal
+// HTML characters are not escaped.
+func encodeJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Max number of digits and max exponent of numbers that are parsed
+// exactly. The exact value of for example 1e1000000 has a million
+// digits, which would make calculations and comparisons very slow.
+const (
+	maxNumberDigits   = 100
+	maxNumberExponent = 400
+)
+
+// Parses number s exactly. Returns false if s is not a number or if it
+// exceeds maxNumberDigits or maxNumberExponent.
+func parseRat(s string) (*big.Rat, bool) {
+	mantissa, exponent, hasExponent := strings.Cut(strings.ToLower(s), "e")
+	digits := 0
+	for _, c := range mantissa {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+	if digits > maxNumberDigits {
+		return nil, false
+	}
+	if hasExponent {
+		e, err := strconv.Atoi(exponent)
+		if err != nil || e > maxNumberExponent || e < -maxNumberExponent {
+			return nil, false
+		}
+	}
+	return new(big.Rat).SetString(s)
+}
+
+// Syntax of numbers accepted by compareNumbers
+var numberRegexp = regexp.MustCompile(`^([+-]?)([0-9]*)\.?([0-9]*)(?:[eE]([+-]?[0-9]+))?$`)
+
+// decimal is a number split into its sign, its significant digits and
+// its exponent, so that the value is 0.<digits> * 10^exp
+type decimal struct {
+	sign   int    // -1, 0 or 1
+	digits string // Without leading and trailing zeros
+	exp    int64
+}
+
+// Splits number s into a decimal. Returns false if s is not a number.
+func parseDecimal(s string) (decimal, bool) {
+	m := numberRegexp.FindStringSubmatch(s)
+	if m == nil || m[2]+m[3] == "" {
+		return decimal{}, false
+	}
+	var exp int64
+	if m[4] != "" {
+		var err error
+		exp, err = strconv.ParseInt(m[4], 10, 64)
+		if err != nil {
+			// Out of int64 range, but still ordered correctly
+			exp = math.MaxInt64 / 2
+			if strings.HasPrefix(m[4], "-") {
+				exp = -exp
+			}
+		}
+	}
+	digits := strings.TrimLeft(m[2]+m[3], "0")
+	exp += int64(len(m[2]) - (len(m[2]+m[3]) - len(digits)))
+	digits = strings.TrimRight(digits, "0")
+	if digits == "" {
+		return decimal{}, true
+	}
+	if m[1] == "-" {
+		return decimal{-1, digits, exp}, true
+	}
+	return decimal{1, digits, exp}, true
+}
+
+// Compares numbers a and b exactly. Returns -1, 0 or 1 if a is less
+// than, equal or larger than b and false if any of them is not a number.
+// In contrast to big.Rat the cost don't depend on the exponents, thus
+// numbers such as 1e1000000 are compared as fast as any other.
+func compareNumbers(a, b string) (int, bool) {
+	da, okA := parseDecimal(a)
+	db, okB := parseDecimal(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	if da.sign != db.sign || da.sign == 0 {
+		return cmp.Compare(da.sign, db.sign), true
+	}
+	magnitude := cmp.Compare(da.exp, db.exp)
+	if magnitude == 0 {
+		magnitude = strings.Compare(da.digits, db.digits)
+	}
+	return da.sign * magnitude, true
+}
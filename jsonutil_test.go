@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateJSON(t *testing.T) {
+	// Valid JSON
+	assertExpectNoErr(t, "", validateJSON([]byte(`{"a": [1, 2, {"b": "c"}]}`), 10, 10))
+	assertExpectNoErr(t, "", validateJSON([]byte(` 12 `), 10, 10))
+	assertExpectNoErr(t, "", validateJSON([]byte(`"str"`), 10, 10))
+
+	// Invalid JSON shall include location
+	err := validateJSON([]byte("{\n  \"a\": 1,\n  \"b\" 2\n}"), 10, 10)
+	assertExpectErr(t, "", err)
+	assertTrue(t, err.Error(), strings.Contains(err.Error(), "line 3"))
+
+	err = validateJSON([]byte(`{"a": 1`), 10, 10)
+	assertExpectErr(t, "", err)
+
+	err = validateJSON([]byte(``), 10, 10)
+	assertExpectErr(t, "", err)
+
+	err = validateJSON([]byte(`not json`), 10, 10)
+	assertExpectErr(t, "", err)
+
+	// Multiple values not allowed
+	err = validateJSON([]byte(`{} {}`), 10, 10)
+	assertExpectErr(t, "", err)
+	assertTrue(t, err.Error(), strings.Contains(err.Error(), "column 3"))
+
+	// Depth limit
+	assertExpectNoErr(t, "", validateJSON([]byte(`[[[]]]`), 3, 10))
+	err = validateJSON([]byte(`[[[[]]]]`), 3, 10)
+	assertExpectErr(t, "", err)
+	assertTrue(t, err.Error(), strings.Contains(err.Error(), "depth"))
+
+	// String length limit on values and keys
+	assertExpectNoErr(t, "", validateJSON([]byte(`{"abc": "def"}`), 3, 3))
+	err = validateJSON([]byte(`{"abc": "defg"}`), 3, 3)
+	assertExpectErr(t, "", err)
+	assertTrue(t, err.Error(), strings.Contains(err.Error(), "string length"))
+	err = validateJSON([]byte(`{"abcd": "def"}`), 3, 3)
+	assertExpectErr(t, "", err)
+}
+
+func TestCompareNumbers(t *testing.T) {
+	compare := func(a, b string) int {
+		t.Helper()
+		cmp, ok := compareNumbers(a, b)
+		assertTrue(t, a+" "+b, ok)
+		return cmp
+	}
+	assertEqualsInt(t, "", -1, compare("2", "10"))
+	assertEqualsInt(t, "", 0, compare("1.0", "1"))
+	assertEqualsInt(t, "Exact", 1, compare("12345678901234567891", "12345678901234567890"))
+	assertEqualsInt(t, "Huge", 1, compare("1e1000000", "1e400"))
+	assertEqualsInt(t, "Huge", -1, compare("-1e1000000", "1"))
+	assertEqualsInt(t, "Huge", -1, compare("1e1000000", "2e1000000"))
+	assertEqualsInt(t, "Tiny", 1, compare("1e-1000000", "0"))
+	assertEqualsInt(t, "", 0, compare("100", "1e2"))
+	assertEqualsInt(t, "", 0, compare("0.012", "1.2e-2"))
+	assertEqualsInt(t, "", 0, compare("-0.0", "0"))
+	assertEqualsInt(t, "", -1, compare("-10", "-2"))
+	assertEqualsInt(t, "", -1, compare("-1", "0.5"))
+	for _, s := range []string{"x", "", "-", ".", "1e", "1/2"} {
+		_, ok := compareNumbers("1", s)
+		assertFalse(t, s, ok)
+	}
+
+	_, ok := parseRat("1e400")
+	assertTrue(t, "", ok)
+	_, ok = parseRat("1e401")
+	assertFalse(t, "", ok)
+	_, ok = parseRat(strings.Repeat("1", 101))
+	assertFalse(t, "", ok)
+}
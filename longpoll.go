@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Max time a GET with ?wait= blocks
+const maxWaitTime = 5 * time.Minute
+
+// changeNotifier notifies waiters about changes of the objects or
+// directories they watch
+type changeNotifier struct {
+	mutex    sync.Mutex
+	watchers map[*changeWatcher]struct{}
+}
+
+// changeWatcher watches an object or a directory for changes
+type changeWatcher struct {
+	watched string        // Object, or directory ending with / ("" = all data)
+	changed chan struct{} // Signalled on changes. Buffered, thus no change is missed.
+}
+
+func newChangeNotifier() *changeNotifier {
+	return &changeNotifier{watchers: make(map[*changeWatcher]struct{})}
+}
+
+// Starts watching object (such as adir/obj.json) or directory (ending
+// with /) watched relative the data directory
+func (n *changeNotifier) watch(watched string) *changeWatcher {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	watcher := &changeWatcher{watched: watched, changed: make(chan struct{}, 1)}
+	n.watchers[watcher] = struct{}{}
+	return watcher
+}
+
+func (n *changeNotifier) unwatch(watcher *changeWatcher) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	delete(n.watchers, watcher)
+}
+
+// Wakes up the watchers of object rel (or directory rel ending with /)
+func (n *changeNotifier) notify(rel string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for watcher := range n.watchers {
+		if watcher.affectedBy(rel) {
+			select {
+			case watcher.changed <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Checks if a change of object rel (or directory rel ending with /)
+// affects the watched object or directory
+func (watcher *changeWatcher) affectedBy(rel string) bool {
+	if watcher.watched == "" || strings.HasSuffix(watcher.watched, "/") {
+		return withinPrefix(watcher.watched, rel)
+	}
+	return rel == watcher.watched ||
+		(strings.HasSuffix(rel, "/") && strings.HasPrefix(watcher.watched, rel))
+}
+
+// bufferedResponse is a http.ResponseWriter which keeps the response in
+// memory, so that it can be inspected before it is sent
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	return b.body.Write(data)
+}
+
+// Sends the buffered response to w
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for key, values := range b.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
+
+// Returns the ETag of a response, or "" if the response is an error (for
+// example if the object don't exist)
+func (b *bufferedResponse) etag() string {
+	if b.status >= 400 {
+		return ""
+	}
+	return b.header.Get("ETag")
+}
+
+// Handles GET with ?wait=<duration>&since=<etag>. Blocks until the ETag
+// of the object or directory differs from since, and then responds as
+// an ordinary GET. 304 Not Modified is returned on timeout. Without
+// since the request blocks until the next change of the current
+// contents.
+func (wa *WebAPI) waitForChange(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	wait, err := time.ParseDuration(query.Get("wait"))
+	if err != nil || wait < 0 {
+		messageResponse(w, http.StatusBadRequest, "Invalid wait: "+query.Get("wait"))
+		return
+	}
+	wait = min(wait, maxWaitTime)
+	since := query.Get("since")
+	if since != "" && !strings.HasPrefix(since, `"`) {
+		since = `"` + since + `"`
+	}
+	hasSince := query.Has("since")
+	dir, file, err := dirAndJsonFile(r.URL.Path)
+	if err != nil {
+		wa.lockedDataGet(r).writeTo(w)
+		return
+	}
+	watched := path.Join(dir, file)
+	if file == "" {
+		watched = strings.TrimPrefix(dir+"/", "./")
+	}
+	// Watch before reading, so that no change is missed
+	watcher := wa.changes.watch(watched)
+	defer wa.changes.unwatch(watcher)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		response := wa.lockedDataGet(r)
+		if !hasSince {
+			since, hasSince = response.etag(), true
+		} else if response.etag() != since {
+			response.writeTo(w)
+			return
+		}
+		select {
+		case <-watcher.changed:
+		case <-timer.C:
+			if since != "" {
+				w.Header().Set("ETag", since)
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Checks if watcher has been signalled
+func isSignalled(watcher *changeWatcher) bool {
+	select {
+	case <-watcher.changed:
+		return true
+	case <-time.After(10 * time.Millisecond):
+		return false
+	}
+}
+
+func TestChangeNotifier(t *testing.T) {
+	n := newChangeNotifier()
+	object := n.watch("app/game/1.json")
+	dir := n.watch("app/game/")
+	all := n.watch("")
+	assertFalse(t, "Changed before notify", isSignalled(object))
+
+	// Writes to unrelated paths only wake the watchers of all data
+	n.notify("app/other.json")
+	assertFalse(t, "Other object", isSignalled(object))
+	assertFalse(t, "Other object", isSignalled(dir))
+	assertTrue(t, "Other object", isSignalled(all))
+	n.notify("app/game/10.json")
+	assertFalse(t, "Similar name", isSignalled(object))
+	assertTrue(t, "Within directory", isSignalled(dir))
+
+	// Changes are kept until received
+	n.notify("app/game/1.json")
+	n.notify("app/game/1.json")
+	assertTrue(t, "", isSignalled(object))
+	assertFalse(t, "Only signalled once", isSignalled(object))
+	assertTrue(t, "", isSignalled(dir))
+
+	// Deleted parent directory
+	n.notify("app/")
+	assertTrue(t, "", isSignalled(object))
+	assertTrue(t, "", isSignalled(dir))
+
+	n.unwatch(object)
+	n.notify("app/game/1.json")
+	assertFalse(t, "Unwatched", isSignalled(object))
+}
@@ -35,6 +35,7 @@ func main() {
 	var tlsEnable = flag.Bool("s", false, "Use secure connection (TLS/HTTPS)")
 	var tlsCertFile = flag.String("c", "cert.pem", "TLS certificate file")
 	var tlsKeyFile = flag.String("k", "key.pem", "TLS key file")
+	var configFile = flag.String("f", "", "Configuration file (JSON)")
 	flag.Parse()
 
 	if *version {
@@ -58,6 +59,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	config, err := LoadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load configuration file %s: %s\n", *configFile, err)
+		os.Exit(1)
+	}
+
 	if *debugEnable {
 		opts := &slog.HandlerOptions{
 			Level: slog.LevelDebug,
@@ -71,7 +78,7 @@ func main() {
 		*tlsKeyFile = ""
 	}
 
-	webAPI := CreateWebAPI(*port, appPath, dataPath, *tlsCertFile, *tlsKeyFile)
+	webAPI := CreateWebAPI(*port, appPath, dataPath, *tlsCertFile, *tlsKeyFile, config)
 	httpServerDone := webAPI.Start()
 	<-httpServerDone // Block until http server is done
 }
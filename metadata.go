@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Directory inside the data directory where metadata of objects is
+// stored. The metadata of object <dir>/<obj>.json is stored in
+// <metaDir>/<dir>/<obj>.json.
+const metaDir = ".meta"
+
+// objectMeta is metadata of a data object, which is stored separately
+// from the object itself
+type objectMeta struct {
+	Expires    *time.Time `json:"expires,omitempty"`    // Object expires at this time
+	ModifiedBy string     `json:"modifiedBy,omitempty"` // User who last modified the object
+}
+
+func (m objectMeta) isEmpty() bool {
+	return m.Expires == nil && m.ModifiedBy == ""
+}
+
+// Reads the metadata of object rel. An object without metadata returns
+// empty metadata.
+func (wa *WebAPI) readMeta(rel string) objectMeta {
+	var meta objectMeta
+	dat, err := os.ReadFile(path.Join(wa.dataPath, metaDir, rel))
+	if err == nil {
+		json.Unmarshal(dat, &meta)
+	}
+	return meta
+}
+
+// Writes the metadata of object rel. Empty metadata is removed.
+func (wa *WebAPI) writeMeta(rel string, meta objectMeta) error {
+	fullPath := path.Join(wa.dataPath, metaDir, rel)
+	if meta.isEmpty() {
+		err := os.Remove(fullPath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	err := os.MkdirAll(path.Dir(fullPath), 0777)
+	if err != nil {
+		return err
+	}
+	dat, _ := json.Marshal(meta)
+	return writeFileAtomic(fullPath, dat, 0666)
+}
+
+// Reads the metadata of the file fullPath inside the data directory
+func (wa *WebAPI) metaOfFile(fullPath string) objectMeta {
+	rel, err := filepath.Rel(wa.dataPath, fullPath)
+	if err != nil {
+		return objectMeta{}
+	}
+	return wa.readMeta(filepath.ToSlash(rel))
+}
+
+// Removes the metadata of object or directory rel
+func (wa *WebAPI) removeMeta(rel string) {
+	os.RemoveAll(path.Join(wa.dataPath, metaDir, rel))
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Operations that can be applied on objects using POST with the op
+// query parameter. The body of the request is the operand.
+const (
+	opAppend = "append" // Appends the elements of the operand array to an array
+	opIncr   = "incr"   // Increments a number with the operand
+	opDecr   = "decr"   // Decrements a number with the operand
+)
+
+// Parses the location within an object that an operation targets. The
+// location is given by the ptr query parameter (JSON Pointer) or by the
+// field query parameter (dotted path). No location means the whole
+// object.
+func operationTarget(query url.Values) ([]string, error) {
+	if query.Has("field") {
+		if query.Has("ptr") {
+			return nil, &statusError{http.StatusBadRequest, "Both field and ptr not allowed"}
+		}
+		return strings.Split(query.Get("field"), "."), nil
+	}
+	tokens, err := parsePointer(query.Get("ptr"))
+	if err != nil {
+		return nil, &statusError{http.StatusBadRequest, err.Error()}
+	}
+	return tokens, nil
+}
+
+// Applies operation op with operand on the current contents of an
+// object. exists is false if the object don't exist. tokens is the
+// location within the object. Returns the new contents and the result
+// to send back to the client.
+func applyOperation(op string, current []byte, exists bool, tokens []string,
+	operand []byte) ([]byte, interface{}, error) {
+	var doc interface{}
+	if exists {
+		var err error
+		doc, err = decodeJSON(current)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if len(tokens) > 0 {
+		doc = map[string]interface{}{}
+	}
+	value, err := decodeJSON(operand)
+	if err != nil {
+		return nil, nil, &statusError{http.StatusBadRequest, err.Error()}
+	}
+	var result interface{}
+	switch op {
+	case opAppend:
+		doc, result, err = appendOperation(doc, tokens, value)
+	case opIncr, opDecr:
+		doc, result, err = incrOperation(doc, tokens, value, op == opDecr)
+	default:
+		return nil, nil, &statusError{http.StatusBadRequest, "Invalid operation: " + op}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	updated, err := encodeJSON(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return updated, result, nil
+}
+
+// Appends the elements of the operand array to the array at tokens. The
+// array is created if it don't exist. Returns the new length.
+func appendOperation(doc interface{}, tokens []string, operand interface{}) (interface{}, interface{}, error) {
+	elements, isArray := operand.([]interface{})
+	if !isArray {
+		return nil, nil, &statusError{http.StatusBadRequest,
+			"Operand of append must be an array of elements"}
+	}
+	target, err := pointerGet(doc, tokens)
+	targetExists := err == nil
+	if !targetExists && !errors.Is(err, errPointerTarget) {
+		return nil, nil, pointerStatusError(err)
+	}
+	if !targetExists || (target == nil && len(tokens) == 0) {
+		target = []interface{}{}
+	}
+	array, isArray := target.([]interface{})
+	if !isArray {
+		return nil, nil, &statusError{http.StatusConflict, "Append target is not an array"}
+	}
+	array = append(array, elements...)
+	if targetExists {
+		doc, err = pointerReplace(doc, tokens, array)
+	} else {
+		doc, err = pointerAdd(doc, tokens, array)
+	}
+	if err != nil {
+		return nil, nil, pointerStatusError(err)
+	}
+	return doc, map[string]int{"length": len(array)}, nil
+}
+
+// Increments (or decrements if decr is true) the number at tokens with
+// the operand. A number that don't exist is created with the value 0
+// before it is incremented. Returns the new value.
+func incrOperation(doc interface{}, tokens []string, operand interface{},
+	decr bool) (interface{}, interface{}, error) {
+	amount, err := parseNumber(operand)
+	if errors.Is(err, errNumberRange) {
+		return nil, nil, &statusError{http.StatusBadRequest, "Operand of incr is out of range"}
+	}
+	if err != nil {
+		return nil, nil, &statusError{http.StatusBadRequest, "Operand of incr must be a number"}
+	}
+	if decr {
+		amount.Neg(amount)
+	}
+	target, err := pointerGet(doc, tokens)
+	targetExists := err == nil
+	if !targetExists && !errors.Is(err, errPointerTarget) {
+		return nil, nil, pointerStatusError(err)
+	}
+	value := new(big.Rat)
+	if targetExists && !(target == nil && len(tokens) == 0) {
+		value, err = parseNumber(target)
+		if errors.Is(err, errNumberRange) {
+			return nil, nil, &statusError{http.StatusConflict, "Incr target is out of range"}
+		}
+		if err != nil {
+			return nil, nil, &statusError{http.StatusConflict, "Incr target is not a number"}
+		}
+	}
+	number := formatNumber(value.Add(value, amount))
+	if targetExists {
+		doc, err = pointerReplace(doc, tokens, number)
+	} else {
+		doc, err = pointerAdd(doc, tokens, number)
+	}
+	if err != nil {
+		return nil, nil, pointerStatusError(err)
+	}
+	return doc, map[string]json.Number{"value": number}, nil
+}
+
+// errNumberRange is returned by parseNumber for numbers exceeding
+// maxNumberDigits or maxNumberExponent
+var errNumberRange = errors.New("number out of range")
+
+// Parses a json.Number created by decodeJSON
+func parseNumber(v interface{}) (*big.Rat, error) {
+	number, isNumber := v.(json.Number)
+	if !isNumber {
+		return nil, errors.New("not a number")
+	}
+	r, ok := parseRat(string(number))
+	if !ok {
+		return nil, errNumberRange
+	}
+	return r, nil
+}
+
+// Formats a number. Integers are formatted without decimals and
+// exponent.
+func formatNumber(r *big.Rat) json.Number {
+	if r.IsInt() {
+		return json.Number(r.Num().String())
+	}
+	f, _ := r.Float64()
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// Applies an operation and checks the new contents
+func assertOperation(t *testing.T, op string, current string, ptr string, operand string,
+	expected string) interface{} {
+	t.Helper()
+	tokens, err := parsePointer(ptr)
+	assertExpectNoErr(t, "", err)
+	updated, result, err := applyOperation(op, []byte(current), current != "", tokens, []byte(operand))
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", expected, string(updated))
+	return result
+}
+
+// Applies an operation and checks that it fails with expected status
+func assertOperationFails(t *testing.T, op string, current string, ptr string, operand string,
+	status int) {
+	t.Helper()
+	tokens, err := parsePointer(ptr)
+	assertExpectNoErr(t, "", err)
+	_, _, err = applyOperation(op, []byte(current), current != "", tokens, []byte(operand))
+	assertExpectErr(t, "", err)
+	statusErr, isStatusErr := err.(*statusError)
+	assertTrue(t, "Not a status error: "+err.Error(), isStatusErr)
+	assertEqualsInt(t, statusErr.message, status, statusErr.status)
+}
+
+func TestAppendOperation(t *testing.T) {
+	result := assertOperation(t, opAppend, `[1,2]`, "", `[3,{"a":4}]`, `[1,2,3,{"a":4}]`)
+	assertEqualsInt(t, "", 4, result.(map[string]int)["length"])
+
+	// Create array in object that don't exist
+	result = assertOperation(t, opAppend, "", "", `[1]`, `[1]`)
+	assertEqualsInt(t, "", 1, result.(map[string]int)["length"])
+	assertOperation(t, opAppend, "", "/shots", `[1]`, `{"shots":[1]}`)
+
+	// Pointer addressed arrays
+	assertOperation(t, opAppend, `{"shots":[1]}`, "/shots", `[2]`, `{"shots":[1,2]}`)
+	assertOperation(t, opAppend, `{"a":1}`, "/shots", `[2]`, `{"a":1,"shots":[2]}`)
+	assertOperation(t, opAppend, `{"s":[[1],[2]]}`, "/s/1", `[3]`, `{"s":[[1],[2,3]]}`)
+
+	// Errors
+	assertOperationFails(t, opAppend, `{"shots":1}`, "/shots", `[2]`, http.StatusConflict)
+	assertOperationFails(t, opAppend, `{}`, "", `[2]`, http.StatusConflict)
+	assertOperationFails(t, opAppend, `[]`, "", `2`, http.StatusBadRequest)
+	assertOperationFails(t, opAppend, `{}`, "/no/shots", `[2]`, http.StatusNotFound)
+	assertOperationFails(t, "invalid", `[]`, "", `[2]`, http.StatusBadRequest)
+}
+
+func TestIncrOperation(t *testing.T) {
+	result := assertOperation(t, opIncr, `{"wins":3}`, "/wins", `1`, `{"wins":4}`)
+	assertEqualsStr(t, "", "4", string(result.(map[string]json.Number)["value"]))
+	assertOperation(t, opDecr, `{"wins":3}`, "/wins", `1`, `{"wins":2}`)
+	assertOperation(t, opIncr, `{"wins":3}`, "/wins", `-5`, `{"wins":-2}`)
+	assertOperation(t, opIncr, `{"t":0.1}`, "/t", `0.2`, `{"t":0.3}`)
+	assertOperation(t, opIncr, `{"t":1.5}`, "/t", `0.5`, `{"t":2}`)
+	assertOperation(t, opIncr, `{"t":12345678901234567890}`, "/t", `1`, `{"t":12345678901234567891}`)
+	assertOperation(t, opIncr, `7`, "", `1`, `8`)
+
+	// Counters that don't exist are created
+	assertOperation(t, opIncr, `{"a":1}`, "/wins", `1`, `{"a":1,"wins":1}`)
+	assertOperation(t, opIncr, "", "/wins", `2`, `{"wins":2}`)
+	assertOperation(t, opIncr, "", "", `2`, `2`)
+
+	// Errors
+	assertOperationFails(t, opIncr, `{"wins":"3"}`, "/wins", `1`, http.StatusConflict)
+	assertOperationFails(t, opIncr, `{"wins":3}`, "/wins", `"1"`, http.StatusBadRequest)
+	assertOperationFails(t, opIncr, `{"wins":3}`, "/stats/wins", `1`, http.StatusNotFound)
+	assertOperationFails(t, opIncr, `{"wins":3}`, "/wins", `1e1000000`, http.StatusBadRequest)
+	assertOperationFails(t, opIncr, `{"wins":1e1000000}`, "/wins", `1`, http.StatusConflict)
+}
+
+func TestOperationTarget(t *testing.T) {
+	tokens, err := operationTarget(url.Values{"field": {"stats.wins"}})
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 2, len(tokens))
+	assertEqualsStr(t, "", "wins", tokens[1])
+
+	tokens, err = operationTarget(url.Values{"ptr": {"/stats/wins"}})
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 2, len(tokens))
+
+	tokens, err = operationTarget(url.Values{})
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 0, len(tokens))
+
+	_, err = operationTarget(url.Values{"ptr": {"stats"}})
+	assertExpectErr(t, "", err)
+	_, err = operationTarget(url.Values{"ptr": {"/a"}, "field": {"a"}})
+	assertExpectErr(t, "", err)
+}
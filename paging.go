@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// pageOptions controls sorting and pagination of directory entries
+type pageOptions struct {
+	sort   string      // name, mtime or dotted path of a field in the objects
+	desc   bool        // Sort in descending order
+	limit  int         // Max entries per page (0 = no limit)
+	cursor *pageCursor // Continue after this entry (nil = first page)
+}
+
+// pageCursor identifies the last entry of a page. It is sent to the
+// client as an opaque (base64 encoded JSON) string.
+type pageCursor struct {
+	Key  interface{} `json:"k"`
+	Name string      `json:"n"`
+}
+
+// pageEntry is a directory entry to be sorted and paginated
+type pageEntry struct {
+	name string      // Name of the object or directory
+	key  interface{} // Sort key, nil if name is the sort key
+}
+
+// Parses the sort, order, limit and cursor query parameters. Returns nil
+// if none of them are set.
+func parsePageOptions(query url.Values) (*pageOptions, error) {
+	if !query.Has("sort") && !query.Has("order") && !query.Has("limit") && !query.Has("cursor") {
+		return nil, nil
+	}
+	p := &pageOptions{sort: "name"}
+	if query.Get("sort") != "" {
+		p.sort = query.Get("sort")
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		p.desc = true
+	default:
+		return nil, fmt.Errorf("invalid order: %s", query.Get("order"))
+	}
+	if query.Has("limit") {
+		var err error
+		p.limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || p.limit < 0 {
+			return nil, fmt.Errorf("invalid limit: %s", query.Get("limit"))
+		}
+	}
+	if query.Get("cursor") != "" {
+		dat, err := base64.RawURLEncoding.DecodeString(query.Get("cursor"))
+		if err == nil {
+			p.cursor = &pageCursor{}
+			dec := json.NewDecoder(bytes.NewReader(dat))
+			dec.UseNumber()
+			err = dec.Decode(p.cursor)
+		}
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+	}
+	return p, nil
+}
+
+// Returns the sort key of a directory entry. dat is the contents of the
+// object, which is only used when sorting on a field. Directories (and
+// objects without the field) have no key and are sorted last.
+func (p *pageOptions) key(info fs.FileInfo, dat []byte) interface{} {
+	switch p.sort {
+	case "name":
+		return nil
+	case "mtime":
+		return json.Number(strconv.FormatInt(info.ModTime().UnixNano(), 10))
+	}
+	if dat == nil {
+		return nil
+	}
+	doc, err := decodeJSON(dat)
+	if err != nil {
+		return nil
+	}
+	value, _ := fieldValue(doc, strings.Split(p.sort, "."))
+	return value
+}
+
+// Sorts the entries and returns the indices of the entries within the
+// page and the cursor to the next page ("" if there are no more
+// entries). Entries are sorted on key, and on name for entries with the
+// same key.
+func (p *pageOptions) page(entries []pageEntry) ([]int, string) {
+	compare := func(a, b pageEntry) int {
+		cmp := compareKeys(a.key, b.key)
+		if cmp == 0 {
+			cmp = strings.Compare(a.name, b.name)
+		}
+		if p.desc {
+			return -cmp
+		}
+		return cmp
+	}
+	indices := make([]int, len(entries))
+	for i := range indices {
+		indices[i] = i
+	}
+	slices.SortStableFunc(indices, func(a, b int) int {
+		return compare(entries[a], entries[b])
+	})
+	if p.cursor != nil {
+		after := pageEntry{name: p.cursor.Name, key: p.cursor.Key}
+		start := len(indices)
+		for i, index := range indices {
+			if compare(entries[index], after) > 0 {
+				start = i
+				break
+			}
+		}
+		indices = indices[start:]
+	}
+	if p.limit == 0 || len(indices) <= p.limit {
+		return indices, ""
+	}
+	indices = indices[:p.limit]
+	last := entries[indices[len(indices)-1]]
+	dat, _ := encodeJSON(pageCursor{Key: last.key, Name: last.name})
+	return indices, base64.RawURLEncoding.EncodeToString(dat)
+}
+
+// Ranks the types of sort keys. Missing keys are sorted last.
+func keyRank(key interface{}) int {
+	switch key.(type) {
+	case bool:
+		return 1
+	case json.Number:
+		return 2
+	case string:
+		return 3
+	case map[string]interface{}, []interface{}:
+		return 4
+	case nil:
+		return 5
+	}
+	return 6
+}
+
+// Compares two sort keys. Keys of different types are ordered by type.
+func compareKeys(a, b interface{}) int {
+	rankA, rankB := keyRank(a), keyRank(b)
+	if rankA != rankB {
+		return rankA - rankB
+	}
+	switch va := a.(type) {
+	case bool:
+		vb := b.(bool)
+		if va == vb {
+			return 0
+		} else if !va {
+			return -1
+		}
+		return 1
+	case json.Number:
+		if cmp, ok := compareNumbers(string(va), string(b.(json.Number))); ok {
+			return cmp
+		}
+		return strings.Compare(string(va), string(b.(json.Number)))
+	case string:
+		return strings.Compare(va, b.(string))
+	}
+	return 0
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+)
+
+func TestParsePageOptions(t *testing.T) {
+	p, err := parsePageOptions(url.Values{})
+	assertExpectNoErr(t, "", err)
+	assertTrue(t, "", p == nil)
+
+	p, err = parsePageOptions(url.Values{"limit": {"10"}})
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", "name", p.sort)
+	assertEqualsInt(t, "", 10, p.limit)
+	assertFalse(t, "", p.desc)
+
+	p, err = parsePageOptions(url.Values{"sort": {"score"}, "order": {"desc"}})
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", "score", p.sort)
+	assertTrue(t, "", p.desc)
+
+	_, err = parsePageOptions(url.Values{"order": {"up"}})
+	assertExpectErr(t, "", err)
+	_, err = parsePageOptions(url.Values{"limit": {"-1"}})
+	assertExpectErr(t, "", err)
+	_, err = parsePageOptions(url.Values{"cursor": {"!!"}})
+	assertExpectErr(t, "", err)
+}
+
+func TestPage(t *testing.T) {
+	entries := []pageEntry{
+		{name: "a", key: json.Number("3")},
+		{name: "b", key: json.Number("1")},
+		{name: "c", key: nil},
+		{name: "d", key: json.Number("2.5")},
+		{name: "e", key: json.Number("1")},
+	}
+	names := func(indices []int) string {
+		result := ""
+		for _, index := range indices {
+			result += entries[index].name
+		}
+		return result
+	}
+
+	// All entries, missing keys last
+	p := &pageOptions{sort: "score"}
+	indices, next := p.page(entries)
+	assertEqualsStr(t, "", "bedac", names(indices))
+	assertEqualsStr(t, "", "", next)
+
+	// Page through all entries
+	p = &pageOptions{sort: "score", limit: 2}
+	result := ""
+	for pages := 0; pages < 10; pages++ {
+		indices, next = p.page(entries)
+		result += names(indices)
+		if next == "" {
+			break
+		}
+		p, _ = parsePageOptions(url.Values{"sort": {"score"}, "limit": {"2"}, "cursor": {next}})
+	}
+	assertEqualsStr(t, "", "bedac", result)
+
+	// Descending order
+	p = &pageOptions{sort: "score", desc: true, limit: 3}
+	indices, next = p.page(entries)
+	assertEqualsStr(t, "", "cad", names(indices))
+	p, _ = parsePageOptions(url.Values{"sort": {"score"}, "order": {"desc"}, "cursor": {next}})
+	indices, _ = p.page(entries)
+	assertEqualsStr(t, "", "eb", names(indices))
+}
+
+func TestCompareKeys(t *testing.T) {
+	assertTrue(t, "", compareKeys(json.Number("2"), json.Number("10")) < 0)
+	assertTrue(t, "", compareKeys("b", "a") > 0)
+	assertTrue(t, "", compareKeys(false, true) < 0)
+	assertTrue(t, "", compareKeys(json.Number("10"), "a") < 0)
+	assertTrue(t, "", compareKeys(nil, "a") > 0)
+	assertEqualsInt(t, "", 0, compareKeys(json.Number("1.0"), json.Number("1")))
+	assertTrue(t, "Huge", compareKeys(json.Number("1e999999"), json.Number("2")) > 0)
+}
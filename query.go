@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// condition is a filter condition of a directory aggregate, such as
+// "players contains alice" or "status=open"
+type condition struct {
+	field []string // Dotted path of the field split into its parts
+	op    string   // One of =, !=, <, <=, >, >=, contains
+	value string
+}
+
+// Operators of conditions
+var conditionOps = []string{" contains ", "!=", "<=", ">=", "=", "<", ">"}
+
+// Parses a condition of the format <field><op><value>. The field is a
+// dotted path, such as status.winner, where numbers address array
+// elements. The first operator in s separates the field from the value
+// (the longest operator if several starts at the same position), thus
+// the value may contain operators but the field may not.
+func parseCondition(s string) (condition, error) {
+	index, op := -1, ""
+	for _, candidate := range conditionOps {
+		i := strings.Index(s, candidate)
+		if i >= 0 && (index < 0 || i < index || (i == index && len(candidate) > len(op))) {
+			index, op = i, candidate
+		}
+	}
+	if index < 0 {
+		return condition{}, fmt.Errorf("invalid condition %q: operator missing", s)
+	}
+	field := strings.TrimSpace(s[:index])
+	if field == "" {
+		return condition{}, fmt.Errorf("invalid condition %q: field missing", s)
+	}
+	return condition{
+		field: strings.Split(field, "."),
+		op:    strings.TrimSpace(op),
+		value: strings.TrimSpace(s[index+len(op):]),
+	}, nil
+}
+
+// Returns the value of a dotted path within doc, which has been created
+// by decodeJSON. Returns false if the field don't exist.
+func fieldValue(doc interface{}, field []string) (interface{}, bool) {
+	for _, name := range field {
+		switch container := doc.(type) {
+		case map[string]interface{}:
+			child, exists := container[name]
+			if !exists {
+				return nil, false
+			}
+			doc = child
+		case []interface{}:
+			index, err := strconv.Atoi(name)
+			if err != nil || index < 0 || index >= len(container) {
+				return nil, false
+			}
+			doc = container[index]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// Checks if doc, created by decodeJSON, fulfills the condition. A
+// document without the field only fulfills the != operator.
+func (c condition) matches(doc interface{}) bool {
+	value, exists := fieldValue(doc, c.field)
+	if !exists {
+		return c.op == "!="
+	}
+	if c.op == "contains" {
+		switch v := value.(type) {
+		case string:
+			return strings.Contains(v, c.value)
+		case []interface{}:
+			for _, element := range v {
+				if compareValue(element, c.value) == 0 {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	cmp := compareValue(value, c.value)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp == -1
+	case "<=":
+		return cmp == -1 || cmp == 0
+	case ">":
+		return cmp == 1
+	case ">=":
+		return cmp == 1 || cmp == 0
+	}
+	return false
+}
+
+// Compares a JSON value with the string representation of a value in a
+// condition. Returns -1, 0 or 1 if value is less than, equal or larger
+// than s. Returns 2 if the values are not comparable.
+func compareValue(value interface{}, s string) int {
+	switch v := value.(type) {
+	case string:
+		return strings.Compare(v, s)
+	case json.Number:
+		if cmp, ok := compareNumbers(string(v), s); ok {
+			return cmp
+		}
+	case bool:
+		if s == strconv.FormatBool(v) {
+			return 0
+		}
+	case nil:
+		if s == "null" {
+			return 0
+		}
+	}
+	return 2
+}
+
+// Parses a comma separated list of dotted field paths, such as
+// "players,status.winner"
+func parseFields(s string) [][]string {
+	var fields [][]string
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			fields = append(fields, strings.Split(field, "."))
+		}
+	}
+	return fields
+}
+
+// Projects doc, created by decodeJSON, so that only the given fields are
+// kept. Arrays are projected element by element. Documents that are
+// neither objects nor arrays are returned unchanged.
+func projectFields(doc interface{}, fields [][]string) interface{} {
+	value, ok := project(doc, fields)
+	if !ok {
+		return doc
+	}
+	return value
+}
+
+// Same as projectFields, but returns false if doc is neither an object
+// nor an array and thus has no fields.
+func project(doc interface{}, fields [][]string) (interface{}, bool) {
+	switch v := doc.(type) {
+	case []interface{}:
+		result := []interface{}{}
+		for _, element := range v {
+			if projected, ok := project(element, fields); ok {
+				result = append(result, projected)
+			}
+		}
+		return result, true
+	case map[string]interface{}:
+		// Group the remaining parts of the fields on the first part
+		var names []string
+		subFields := make(map[string][][]string)
+		wholeChild := make(map[string]bool)
+		for _, field := range fields {
+			if _, exists := subFields[field[0]]; !exists {
+				names = append(names, field[0])
+				subFields[field[0]] = [][]string{}
+			}
+			if len(field) == 1 {
+				wholeChild[field[0]] = true
+			} else {
+				subFields[field[0]] = append(subFields[field[0]], field[1:])
+			}
+		}
+		result := make(map[string]interface{})
+		for _, name := range names {
+			child, exists := v[name]
+			if !exists {
+				continue
+			}
+			if wholeChild[name] {
+				result[name] = child
+			} else if projected, ok := project(child, subFields[name]); ok {
+				result[name] = projected
+			}
+		}
+		return result, true
+	}
+	return nil, false
+}
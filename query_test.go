@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseCondition(t *testing.T) {
+	c, err := parseCondition("players contains alice")
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", "players", c.field[0])
+	assertEqualsStr(t, "", "contains", c.op)
+	assertEqualsStr(t, "", "alice", c.value)
+
+	c, err = parseCondition("status.open!=true")
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 2, len(c.field))
+	assertEqualsStr(t, "", "open", c.field[1])
+	assertEqualsStr(t, "", "!=", c.op)
+	assertEqualsStr(t, "", "true", c.value)
+
+	c, err = parseCondition("turn >= 3")
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", ">=", c.op)
+	assertEqualsStr(t, "", "3", c.value)
+
+	// The first operator separates field and value
+	c, err = parseCondition("title=a!=b")
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", "title", c.field[0])
+	assertEqualsStr(t, "", "=", c.op)
+	assertEqualsStr(t, "", "a!=b", c.value)
+
+	c, err = parseCondition("title contains x>=y")
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", "contains", c.op)
+	assertEqualsStr(t, "", "x>=y", c.value)
+
+	c, err = parseCondition("turn<=>3")
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", "<=", c.op)
+	assertEqualsStr(t, "", ">3", c.value)
+
+	_, err = parseCondition("status")
+	assertExpectErr(t, "", err)
+	_, err = parseCondition("=open")
+	assertExpectErr(t, "", err)
+}
+
+func TestConditionMatches(t *testing.T) {
+	doc, _ := decodeJSON([]byte(`{"players": ["alice", "bob"], "status": "open",
+		"turn": 3, "done": false, "winner": null, "board": {"size": 9}}`))
+	matches := func(s string) bool {
+		c, err := parseCondition(s)
+		assertExpectNoErr(t, "", err)
+		return c.matches(doc)
+	}
+	assertTrue(t, "", matches("players contains alice"))
+	assertFalse(t, "", matches("players contains carol"))
+	assertTrue(t, "", matches("status contains pe"))
+	assertTrue(t, "", matches("status=open"))
+	assertFalse(t, "", matches("status=closed"))
+	assertTrue(t, "", matches("status!=closed"))
+	assertTrue(t, "", matches("turn=3"))
+	assertTrue(t, "", matches("turn=3.0"))
+	assertTrue(t, "", matches("turn>2"))
+	assertTrue(t, "", matches("turn<=3"))
+	assertFalse(t, "", matches("turn<3"))
+	assertTrue(t, "", matches("done=false"))
+	assertTrue(t, "", matches("winner=null"))
+	assertTrue(t, "", matches("board.size=9"))
+	assertTrue(t, "", matches("players.1=bob"))
+	assertFalse(t, "", matches("missing=x"))
+	assertTrue(t, "", matches("missing!=x"))
+	assertFalse(t, "Not comparable", matches("turn>abc"))
+	assertFalse(t, "Huge", matches("turn>1e999999"))
+	assertTrue(t, "Huge", matches("turn>-1e999999"))
+}
+
+func TestProjectFields(t *testing.T) {
+	projectStr := func(doc string, fields string) string {
+		v, err := decodeJSON([]byte(doc))
+		assertExpectNoErr(t, "", err)
+		result, err := encodeJSON(projectFields(v, parseFields(fields)))
+		assertExpectNoErr(t, "", err)
+		return string(result)
+	}
+	game := `{"players":["a","b"],"status":{"open":true,"winner":null},"board":[[1,2],[3,4]]}`
+	assertEqualsStr(t, "", `{"players":["a","b"]}`, projectStr(game, "players"))
+	assertEqualsStr(t, "", `{"players":["a","b"],"status":{"open":true,"winner":null}}`,
+		projectStr(game, "players, status"))
+	assertEqualsStr(t, "", `{"status":{"winner":null}}`, projectStr(game, "status.winner"))
+	assertEqualsStr(t, "", `{"status":{"open":true,"winner":null}}`,
+		projectStr(game, "status.winner,status"))
+	assertEqualsStr(t, "", `{"players":[]}`, projectStr(game, "missing,players.x"))
+
+	// Arrays are projected element by element
+	clubs := `[{"name":"7i","distance":120,"loft":34},{"name":"D","distance":200}]`
+	assertEqualsStr(t, "", `[{"distance":120},{"distance":200}]`, projectStr(clubs, "distance"))
+	assertEqualsStr(t, "", `{"clubs":[{"name":"7i"},{"name":"D"}]}`,
+		projectStr(`{"clubs":`+clubs+`,"balls":3}`, "clubs.name"))
+
+	// Other documents are unchanged
+	assertEqualsStr(t, "", `12`, projectStr(`12`, "a"))
+}
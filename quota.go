@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// usage is the storage used by an app
+type usage struct {
+	Bytes        int64 `json:"bytes"`                // Total size of the objects
+	Objects      int   `json:"objects"`              // Number of objects
+	HistoryBytes int64 `json:"historyBytes"`         // Total size of the previous revisions of the objects
+	MaxBytes     int64 `json:"maxBytes,omitempty"`   // Quota of the size (if any)
+	MaxObjects   int   `json:"maxObjects,omitempty"` // Quota of the number of objects (if any)
+}
+
+// usages keeps track of the storage used by each app. The usage of an
+// app is calculated when first needed and then kept up to date by the
+// modifications made through waserver, so that the data directory
+// doesn't need to be scanned on each write.
+type usages struct {
+	mutex sync.Mutex
+	apps  map[string]*usage
+}
+
+func newUsages() *usages {
+	return &usages{apps: make(map[string]*usage)}
+}
+
+// Returns the app, i.e. the top level directory, of object rel. Objects
+// directly in the data directory don't belong to any app ("" is returned).
+func appOf(rel string) string {
+	app, _, found := strings.Cut(rel, "/")
+	if !found {
+		return ""
+	}
+	return app
+}
+
+// Returns the quota of app according to the configuration
+func (wa *WebAPI) quotaOf(app string) (Quota, bool) {
+	if quota, exists := wa.config.Quotas[app]; exists {
+		return quota, true
+	}
+	quota, exists := wa.config.Quotas["*"]
+	return quota, exists
+}
+
+// Returns the storage used by app, including its quota
+func (wa *WebAPI) usageOf(app string) usage {
+	wa.usages.mutex.Lock()
+	cached, exists := wa.usages.apps[app]
+	if !exists {
+		calculated := wa.calculateUsage(app)
+		cached = &calculated
+		wa.usages.apps[app] = cached
+	}
+	result := *cached
+	wa.usages.mutex.Unlock()
+	quota, _ := wa.quotaOf(app)
+	result.MaxBytes = quota.MaxBytes
+	result.MaxObjects = quota.MaxObjects
+	return result
+}
+
+// Updates the usage of the app of object rel with the size of a write
+// or removal of the object (bytes and objects) or of its history
+func (wa *WebAPI) addUsage(rel string, bytes int64, objects int, historyBytes int64) {
+	wa.usages.mutex.Lock()
+	defer wa.usages.mutex.Unlock()
+	if u, exists := wa.usages.apps[appOf(rel)]; exists {
+		u.Bytes += bytes
+		u.Objects += objects
+		u.HistoryBytes += historyBytes
+	}
+}
+
+// Forgets the usage of the app of directory or object rel, so that it
+// is calculated again when needed. Used after modifications that are
+// not tracked by addUsage.
+func (wa *WebAPI) resetUsage(rel string) {
+	wa.usages.mutex.Lock()
+	defer wa.usages.mutex.Unlock()
+	app, _, _ := strings.Cut(rel, "/")
+	if app == "." || app == "" {
+		clear(wa.usages.apps)
+	} else {
+		delete(wa.usages.apps, app)
+	}
+}
+
+// Calculates the storage used by app by scanning its directory and its
+// history. Hidden files and directories are not included.
+func (wa *WebAPI) calculateUsage(app string) usage {
+	var result usage
+	fullDir := path.Join(wa.dataPath, app)
+	filepath.WalkDir(fullDir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") && name != fullDir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || path.Ext(name) != ".json" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		result.Bytes += info.Size()
+		result.Objects++
+		return nil
+	})
+	filepath.WalkDir(path.Join(wa.dataPath, historyDir, app), func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(name) != ".json" {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			result.HistoryBytes += info.Size()
+		}
+		return nil
+	})
+	return result
+}
+
+// Checks that writing data to object rel don't exceed the quota of the
+// app. Writes that don't increase the usage are always allowed, so that
+// an app above its quota can remove data. The history of the app is
+// not checked here, see trimHistory. Objects outside of an app (directly
+// in the data directory) are not covered by any quota, thus they are
+// rejected when quotas are configured.
+func (wa *WebAPI) checkQuota(rel string, data []byte) error {
+	app := appOf(rel)
+	quota, exists := wa.quotaOf(app)
+	if app == "" && len(wa.config.Quotas) > 0 {
+		return &statusError{http.StatusForbidden,
+			"Objects outside of an app are not allowed when quotas are configured"}
+	}
+	if app == "" || !exists {
+		return nil
+	}
+	current := wa.usageOf(app)
+	updated := current
+	info, err := os.Stat(path.Join(wa.dataPath, rel))
+	if err == nil {
+		updated.Bytes -= info.Size()
+	} else {
+		updated.Objects++
+	}
+	updated.Bytes += int64(len(data))
+	if quota.MaxBytes > 0 && updated.Bytes > quota.MaxBytes && updated.Bytes > current.Bytes {
+		return &statusError{http.StatusInsufficientStorage,
+			fmt.Sprintf("Quota of app %s exceeded (max %d bytes)", app, quota.MaxBytes)}
+	}
+	if quota.MaxObjects > 0 && updated.Objects > quota.MaxObjects && updated.Objects > current.Objects {
+		return &statusError{http.StatusInsufficientStorage,
+			fmt.Sprintf("Quota of app %s exceeded (max %d objects)", app, quota.MaxObjects)}
+	}
+	return nil
+}
+
+// Removes the oldest revisions of the app of object rel (or directory
+// rel ending with /) while the app exceeds its max bytes. The history
+// is included in the max bytes, but rather than rejecting a write the
+// revisions are removed. This is done after the modification has been
+// committed, since revisions removed for a write that fails or is
+// rolled back could not be restored. Thus the app may exceed its max
+// bytes by the latest revisions until then.
+func (wa *WebAPI) trimHistory(rel string) {
+	app := appOf(rel)
+	quota, exists := wa.quotaOf(app)
+	if app == "" || !exists || quota.MaxBytes <= 0 {
+		return
+	}
+	current := wa.usageOf(app)
+	if excess := current.Bytes + current.HistoryBytes - quota.MaxBytes; excess > 0 {
+		slog.Debug(fmt.Sprintf("Removing %d bytes of history of app %s to stay within quota", excess, app))
+		wa.history.purge(app, excess)
+	}
+}
+
+func (wa *WebAPI) handleUsageGet(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("GET USAGE")
+	filesMap, err := listFilesMap(wa.dataPath)
+	if err != nil {
+		messageResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	removeHidden(filesMap)
+	result := make(map[string]usage)
+	for _, app := range filesMap["dirs"] {
+		result[app] = wa.usageOf(app)
+	}
+	usageJson, _ := json.Marshal(result)
+	writeResponseStr(w, http.StatusOK, string(usageJson))
+}
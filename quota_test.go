@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestAppOf(t *testing.T) {
+	assertEqualsStr(t, "", "myapp", appOf("myapp/obj.json"))
+	assertEqualsStr(t, "", "myapp", appOf("myapp/a/b/obj.json"))
+	assertEqualsStr(t, "", "", appOf("obj.json"))
+}
+
+func TestCheckQuota(t *testing.T) {
+	config := DefaultConfig()
+	config.Quotas = map[string]Quota{
+		"small": {MaxBytes: 10},
+		"few":   {MaxObjects: 2},
+		"*":     {MaxObjects: 1},
+	}
+	wa := newTestWebAPI(t, config)
+	dataDir := wa.dataPath
+	write := func(rel, data string) {
+		os.MkdirAll(path.Dir(path.Join(dataDir, rel)), 0777)
+		os.WriteFile(path.Join(dataDir, rel), []byte(data), 0666)
+		wa.resetUsage(rel)
+	}
+
+	assertExpectNoErr(t, "", wa.checkQuota("small/a.json", []byte(`"12345678"`)))
+	assertExpectErr(t, "", wa.checkQuota("small/a.json", []byte(`"123456789"`)))
+	write("small/a.json", `"123456"`)
+	assertExpectNoErr(t, "", wa.checkQuota("small/b.json", []byte(`12`)))
+	assertExpectErr(t, "", wa.checkQuota("small/b.json", []byte(`123`)))
+	assertExpectNoErr(t, "Replace", wa.checkQuota("small/a.json", []byte(`"1234567"`)))
+
+	write("few/a.json", `1`)
+	write("few/sub/b.json", `2`)
+	write("few/.hidden/c.json", `3`)
+	assertExpectNoErr(t, "Update", wa.checkQuota("few/a.json", []byte(`11`)))
+	assertExpectErr(t, "New object", wa.checkQuota("few/c.json", []byte(`1`)))
+
+	// Lowered quota, writes that don't increase the usage are allowed
+	write("small/a.json", `"1234567890123"`)
+	assertExpectNoErr(t, "Shrink", wa.checkQuota("small/a.json", []byte(`1`)))
+	assertExpectErr(t, "Grow", wa.checkQuota("small/a.json", []byte(`"12345678901234"`)))
+
+	// Default quota and objects outside apps
+	assertExpectNoErr(t, "", wa.checkQuota("other/a.json", []byte(`1`)))
+	write("other/a.json", `1`)
+	assertExpectErr(t, "", wa.checkQuota("other/b.json", []byte(`1`)))
+	assertExpectErr(t, "", wa.checkQuota("root.json", []byte(`1`)))
+
+	u := wa.usageOf("few")
+	assertEqualsInt(t, "", 2, u.Objects)
+	assertEqualsInt(t, "", 2, int(u.Bytes))
+	assertEqualsInt(t, "", 2, u.MaxObjects)
+}
+
+func TestUsageWithHistory(t *testing.T) {
+	config := DefaultConfig()
+	config.Quotas = map[string]Quota{"app": {MaxBytes: 30}}
+	wa := newTestWebAPI(t, config)
+	assertUsage := func(bytes, objects, historyBytes int) {
+		t.Helper()
+		u := wa.usageOf("app")
+		assertEqualsInt(t, "Bytes", bytes, int(u.Bytes))
+		assertEqualsInt(t, "Objects", objects, u.Objects)
+		assertEqualsInt(t, "History", historyBytes, int(u.HistoryBytes))
+		assertEqualsStr(t, "Cached usage", fmt.Sprint(wa.calculateUsage("app")),
+			fmt.Sprint(usage{Bytes: u.Bytes, Objects: u.Objects, HistoryBytes: u.HistoryBytes}))
+	}
+
+	assertExpectNoErr(t, "", wa.storeObject("app/a.json", []byte(`"12345678"`), writeOptions{}))
+	assertUsage(10, 1, 0)
+	assertExpectNoErr(t, "", wa.storeObject("app/a.json", []byte(`"abcdefgh"`), writeOptions{}))
+	assertUsage(10, 1, 10)
+	assertExpectNoErr(t, "", wa.storeObject("app/b.json", []byte(`"12345678"`), writeOptions{}))
+	assertUsage(20, 2, 10)
+
+	// Checking the quota doesn't remove any revisions
+	assertExpectNoErr(t, "", wa.checkQuota("app/a.json", []byte(`"ABCDEFGH"`)))
+	assertUsage(20, 2, 10)
+
+	// The oldest revisions are removed after the write to make room
+	assertExpectNoErr(t, "", wa.storeObject("app/a.json", []byte(`"ABCDEFGH"`), writeOptions{}))
+	assertUsage(20, 2, 10)
+	revs, _ := wa.history.list("app/a.json")
+	assertEqualsInt(t, "", 1, len(revs))
+	dat, _ := wa.history.read("app/a.json", revs[0].Rev)
+	assertEqualsStr(t, "Latest revision kept", `"abcdefgh"`, string(dat))
+
+	// Objects created and deleted don't fill up the history
+	for i := 0; i < 20; i++ {
+		rel := fmt.Sprintf("app/tmp%d.json", i)
+		assertExpectNoErr(t, "", wa.storeObject(rel, []byte(`"12345678"`), writeOptions{}))
+		assertExpectNoErr(t, "", wa.removeObject(rel))
+		u := wa.usageOf("app")
+		assertTrue(t, "Within quota", u.Bytes+u.HistoryBytes <= 30)
+	}
+	assertUsage(20, 2, 10)
+
+	// Objects exceeding the quota are still rejected
+	assertExpectErr(t, "", wa.storeObject("app/c.json", []byte(`"1234567890123"`), writeOptions{}))
+
+	// Directory removal recalculates the usage
+	assertExpectNoErr(t, "", wa.removeDirectory("app"))
+	assertEqualsInt(t, "", 0, wa.usageOf("app").Objects)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Directory inside an app data directory where the state of the rooms
+// is persisted. The state of room <room> of app <app> is thus available
+// as /data/<app>/rooms/<room>.
+const roomsDir = "rooms"
+
+// Max number of messages queued to a room member. A member with a full
+// queue doesn't keep up with the room and is disconnected.
+const memberQueueSize = 64
+
+// roomMember is a client connected to a room
+type roomMember struct {
+	id    string
+	user  string // Logged in user ("" = anonymous)
+	conn  *wsConn
+	queue chan []byte   // Messages to send to the member
+	done  chan struct{} // Closed when the member has left
+}
+
+// rooms keeps track of the members of all rooms
+type rooms struct {
+	mutex   sync.Mutex
+	members map[string][]*roomMember // Members per room (<app>/<room>)
+}
+
+// roomRequest is a message sent by a client to a room
+type roomRequest struct {
+	Type string          `json:"type"` // message (relayed) or state (persisted and relayed)
+	Data json.RawMessage `json:"data"`
+}
+
+func newRoomMember(id string, user string, conn *wsConn) *roomMember {
+	return &roomMember{
+		id:    id,
+		user:  user,
+		conn:  conn,
+		queue: make(chan []byte, memberQueueSize),
+		done:  make(chan struct{}),
+	}
+}
+
+func newRooms() *rooms {
+	return &rooms{members: make(map[string][]*roomMember)}
+}
+
+// Adds member to room name. Returns the IDs of the other members.
+func (rs *rooms) join(name string, member *roomMember) []string {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	ids := []string{}
+	for _, other := range rs.members[name] {
+		ids = append(ids, other.id)
+	}
+	rs.members[name] = append(rs.members[name], member)
+	return ids
+}
+
+// Removes member from room name
+func (rs *rooms) leave(name string, member *roomMember) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	rs.members[name] = slices.DeleteFunc(rs.members[name], func(m *roomMember) bool {
+		return m == member
+	})
+	if len(rs.members[name]) == 0 {
+		delete(rs.members, name)
+	}
+}
+
+// Sends message to all members of room name except the sender
+func (rs *rooms) broadcast(name string, sender *roomMember, message interface{}) {
+	messageJson, _ := json.Marshal(message)
+	rs.mutex.Lock()
+	others := slices.DeleteFunc(slices.Clone(rs.members[name]), func(m *roomMember) bool {
+		return m == sender
+	})
+	rs.mutex.Unlock()
+	for _, other := range others {
+		other.enqueue(messageJson)
+	}
+}
+
+// Closes the connections of all members of all rooms
+func (rs *rooms) closeAll() {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	for _, members := range rs.members {
+		for _, member := range members {
+			member.conn.writeFrame(wsClose, nil)
+			member.conn.close()
+		}
+	}
+}
+
+// Sends a message to one member
+func (member *roomMember) send(message interface{}) {
+	messageJson, _ := json.Marshal(message)
+	member.enqueue(messageJson)
+}
+
+// Queues a message to the member. The connection of a member with a full
+// queue is closed, so that a slow member doesn't hold up the others.
+func (member *roomMember) enqueue(message []byte) {
+	select {
+	case member.queue <- message:
+	default:
+		slog.Debug("Room member " + member.id + " is too slow, disconnecting")
+		member.conn.close()
+	}
+}
+
+// Writes the queued messages to the member until the member has left
+func (member *roomMember) run() {
+	for {
+		select {
+		case message := <-member.queue:
+			err := member.conn.writeText(message)
+			if err != nil {
+				// The reader of the member will detect the broken connection
+				slog.Debug("Unable to send to room member " + member.id + ": " + err.Error())
+				member.conn.close()
+				return
+			}
+		case <-member.done:
+			return
+		}
+	}
+}
+
+func (wa *WebAPI) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("WS " + r.URL.Path)
+	app, roomName := r.PathValue("app"), r.PathValue("room")
+	dir, file, err := dirAndJsonFile("/data/" + path.Join(app, roomsDir, roomName))
+	if err != nil || file == "" || strings.Contains(roomName, "/") {
+		messageResponse(w, http.StatusForbidden, "Invalid room: "+r.URL.Path)
+		return
+	}
+	rel := path.Join(dir, file)
+	conn, err := upgradeWebSocket(w, r, wa.config.MaxBodySize, wa.config.WebSocketOrigins)
+	if err != nil {
+		slog.Debug("WebSocket upgrade failed: " + err.Error())
+		return
+	}
+	defer conn.close()
+
+	name := path.Join(app, roomName)
+	member := newRoomMember(wa.ids.next(), userOf(r), conn)
+	go member.run()
+	defer close(member.done)
+	welcome := map[string]interface{}{
+		"type":    "welcome",
+		"id":      member.id,
+		"members": wa.rooms.join(name, member),
+	}
+	defer func() {
+		wa.rooms.leave(name, member)
+		wa.rooms.broadcast(name, member, map[string]string{"type": "leave", "id": member.id})
+	}()
+	if state, err := wa.readObject(rel); err == nil {
+		welcome["state"] = json.RawMessage(state)
+	}
+	member.send(welcome)
+	wa.rooms.broadcast(name, member, map[string]string{"type": "join", "id": member.id})
+
+	for {
+		opcode, message, err := conn.readMessage()
+		if err != nil {
+			return
+		}
+		err = wa.roomMessage(name, rel, member, opcode, message)
+		if err != nil {
+			member.send(map[string]string{"type": "error", "message": err.Error()})
+		}
+	}
+}
+
+// Handles a message from a member of room name, whose state is persisted
+// in object rel
+func (wa *WebAPI) roomMessage(name string, rel string, member *roomMember,
+	opcode byte, message []byte) error {
+	if opcode != wsText {
+		return &statusError{http.StatusBadRequest, "Only text messages supported"}
+	}
+	err := validateJSON(message, wa.config.MaxDepth, wa.config.MaxStringLength)
+	if err != nil {
+		return err
+	}
+	var request roomRequest
+	err = json.Unmarshal(message, &request)
+	if err != nil || len(request.Data) == 0 {
+		return &statusError{http.StatusBadRequest, `Expected {"type": ..., "data": ...}`}
+	}
+	switch request.Type {
+	case "message":
+	case "state":
+		wa.mutex.Lock()
+		err = wa.storeObject(rel, request.Data, writeOptions{user: member.user})
+		wa.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+	default:
+		return &statusError{http.StatusBadRequest, "Invalid type: " + request.Type}
+	}
+	wa.rooms.broadcast(name, member, map[string]interface{}{
+		"type": request.Type,
+		"from": member.id,
+		"data": request.Data,
+	})
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRoomMemberQueue(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	client := &wsConn{conn: clientConn, reader: bufio.NewReader(clientConn), maxSize: 1 << 20, client: true}
+	defer client.close()
+	member := newRoomMember("1", "", &wsConn{conn: serverConn, reader: bufio.NewReader(serverConn), maxSize: 1 << 20})
+	go member.run()
+	defer close(member.done)
+
+	// The messages are sent in order
+	member.send("a")
+	member.send("b")
+	for _, expected := range []string{`"a"`, `"b"`} {
+		_, message, err := client.readMessage()
+		assertExpectNoErr(t, "", err)
+		assertEqualsStr(t, "", expected, string(message))
+	}
+
+	// A member which doesn't read the messages is disconnected when the
+	// queue is full, without blocking the sender
+	sent := make(chan bool)
+	go func() {
+		for i := 0; i <= memberQueueSize+1; i++ {
+			member.send(i)
+		}
+		sent <- true
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sending to a slow member blocked")
+	}
+	client.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := client.readMessage()
+		if err != nil {
+			assertFalse(t, "Disconnected", errors.Is(err, os.ErrDeadlineExceeded))
+			break
+		}
+	}
+}
@@ -0,0 +1,650 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Name of the file with the JSON schema of the objects in a directory
+const schemaFile = "_schema.json"
+
+// Keyword of a schema which makes the schema apply to the objects in all
+// subdirectories as well (unless they have an own schema)
+const subdirectoriesKeyword = "x-subdirectories"
+
+// Max number of compiled patterns kept in patternCache
+const maxCachedPatterns = 1000
+
+// Compiled regular expressions of the patterns of the schemas, so that
+// the patterns are not compiled for every validated object
+var patternCache = struct {
+	sync.Mutex
+	patterns map[string]*regexp.Regexp
+}{patterns: make(map[string]*regexp.Regexp)}
+
+// Compiles a pattern of a schema, or gets it from patternCache if it
+// has been compiled before
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	patternCache.Lock()
+	defer patternCache.Unlock()
+	if re, exists := patternCache.patterns[pattern]; exists {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(patternCache.patterns) >= maxCachedPatterns {
+		clear(patternCache.patterns)
+	}
+	patternCache.patterns[pattern] = re
+	return re, nil
+}
+
+// schemaError is a validation error of an object
+type schemaError struct {
+	Path    string `json:"path"`    // JSON pointer to the invalid value
+	Message string `json:"message"` // What is wrong
+}
+
+// validationError is returned when an object is not valid according to
+// the schema of its directory
+type validationError struct {
+	errors []schemaError
+}
+
+func (e *validationError) Error() string {
+	var messages []string
+	for _, err := range e.errors {
+		messages = append(messages, err.Path+": "+err.Message)
+	}
+	return "Schema validation failed: " + strings.Join(messages, ", ")
+}
+
+// Finds the schema of object rel. The schema of the directory of the
+// object applies, or if there is none, the closest schema of a parent
+// directory with x-subdirectories set. Returns nil if there is no schema.
+func (wa *WebAPI) schemaOf(rel string) (interface{}, error) {
+	dir := path.Dir(rel)
+	for first := true; ; first = false {
+		dat, err := os.ReadFile(path.Join(wa.dataPath, dir, schemaFile))
+		if err == nil {
+			schema, err := decodeJSON(dat)
+			if err != nil {
+				return nil, err
+			}
+			schemaMap, isMap := schema.(map[string]interface{})
+			if first || (isMap && schemaMap[subdirectoriesKeyword] == true) {
+				return schema, nil
+			}
+		}
+		if dir == "." || dir == "" {
+			return nil, nil
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// Returns the directory, relative the data directory, of a
+// /service/schema/ request
+func schemaDir(r *http.Request) (string, error) {
+	dir := strings.Trim(r.PathValue("dir"), "/")
+	if dir != "" {
+		dir += "/"
+	}
+	dir, _, err := dirAndJsonFile("/data/" + dir)
+	return dir, err
+}
+
+func (wa *WebAPI) handleSchemaGet(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("GET " + r.URL.Path)
+	dir, err := schemaDir(r)
+	if err != nil {
+		messageResponse(w, http.StatusForbidden, err.Error())
+		return
+	}
+	wa.mutex.RLock()
+	dat, err := os.ReadFile(path.Join(wa.dataPath, dir, schemaFile))
+	wa.mutex.RUnlock()
+	if err != nil {
+		messageResponse(w, http.StatusNotFound, "No schema in "+dir)
+		return
+	}
+	w.Header().Set("ETag", etagOf(dat))
+	writeResponseStr(w, http.StatusOK, string(dat))
+}
+
+func (wa *WebAPI) handleSchemaPost(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("POST " + r.URL.Path)
+	dir, err := schemaDir(r)
+	if err != nil {
+		messageResponse(w, http.StatusForbidden, err.Error())
+		return
+	}
+	body, ok := wa.readJSONBody(w, r)
+	if !ok {
+		return
+	}
+	wa.mutex.Lock()
+	defer wa.mutex.Unlock()
+	err = wa.storeObject(path.Join(dir, schemaFile), body, writeOptions{user: userOf(r)})
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	w.Header().Set("ETag", etagOf(body))
+	messageResponse(w, http.StatusOK, "Schema of "+dir+" stored")
+}
+
+func (wa *WebAPI) handleSchemaDelete(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("DELETE " + r.URL.Path)
+	dir, err := schemaDir(r)
+	if err != nil {
+		messageResponse(w, http.StatusForbidden, err.Error())
+		return
+	}
+	wa.mutex.Lock()
+	defer wa.mutex.Unlock()
+	err = wa.removeObject(path.Join(dir, schemaFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		messageResponse(w, http.StatusNotFound, "No schema in "+dir)
+		return
+	}
+	if err != nil {
+		messageResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	messageResponse(w, http.StatusOK, "Schema of "+dir+" deleted")
+}
+
+// Validates data written to object rel against the schema of its
+// directory. Schemas themselves are checked to be valid schemas.
+func (wa *WebAPI) validateSchema(rel string, data []byte) error {
+	if path.Base(rel) == schemaFile {
+		schema, err := decodeJSON(data)
+		if err == nil {
+			err = checkSchema(schema)
+		}
+		if err != nil {
+			return &statusError{http.StatusUnprocessableEntity, "Invalid schema: " + err.Error()}
+		}
+		return nil
+	}
+	schema, err := wa.schemaOf(rel)
+	if err != nil || schema == nil {
+		// An invalid schema file can only be created outside waserver
+		return nil
+	}
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return &statusError{http.StatusBadRequest, err.Error()}
+	}
+	v := newSchemaValidator(schema)
+	v.validate(schema, doc, "")
+	if v.aborted() {
+		return &statusError{http.StatusUnprocessableEntity, "Schema too complex to validate"}
+	}
+	if len(v.errors) > 0 {
+		return &validationError{v.errors}
+	}
+	return nil
+}
+
+// Checks that a schema is an object or a boolean, that all patterns are
+// valid regular expressions and that there are no $ref cycles
+func checkSchema(schema interface{}) error {
+	switch schema.(type) {
+	case bool, map[string]interface{}:
+		err := checkPatterns(schema)
+		if err != nil {
+			return err
+		}
+		return checkRefCycles(schema)
+	}
+	return errors.New("a schema must be an object or a boolean")
+}
+
+// Checks that a schema has no $ref cycles which don't consume any part of
+// the validated value, such as {"anyOf":[{"$ref":"#"}]}. Such a schema
+// never terminates (or takes exponential time within maxRefDepth).
+func checkRefCycles(root interface{}) error {
+	nodes := make(map[string]map[string]interface{})
+	collectSchemaNodes(root, "", nodes)
+	const visiting, visited = 1, 2
+	state := make(map[string]int)
+	var visit func(ptr string) error
+	visit = func(ptr string) error {
+		switch state[ptr] {
+		case visiting:
+			return fmt.Errorf("$ref cycle at #%s", ptr)
+		case visited:
+			return nil
+		}
+		state[ptr] = visiting
+		for _, next := range sameValueSchemas(nodes[ptr], ptr) {
+			if _, exists := nodes[next]; exists {
+				if err := visit(next); err != nil {
+					return err
+				}
+			}
+		}
+		state[ptr] = visited
+		return nil
+	}
+	ptrs := make([]string, 0, len(nodes))
+	for ptr := range nodes {
+		ptrs = append(ptrs, ptr)
+	}
+	slices.Sort(ptrs)
+	for _, ptr := range ptrs {
+		if err := visit(ptr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Collects all objects of a schema by their JSON pointer
+func collectSchemaNodes(schema interface{}, ptr string, nodes map[string]map[string]interface{}) {
+	switch s := schema.(type) {
+	case map[string]interface{}:
+		nodes[ptr] = s
+		for keyword, child := range s {
+			switch keyword {
+			case "const", "enum", "default", "examples":
+				// Values, not schemas
+				continue
+			}
+			collectSchemaNodes(child, ptr+"/"+pointerEscape(keyword), nodes)
+		}
+	case []interface{}:
+		for i, child := range s {
+			collectSchemaNodes(child, ptr+"/"+strconv.Itoa(i), nodes)
+		}
+	}
+}
+
+// Returns the JSON pointers of the subschemas which are applied on the
+// same value as schema s at ptr, i.e. $ref, allOf, anyOf, oneOf and not
+func sameValueSchemas(s map[string]interface{}, ptr string) []string {
+	var result []string
+	if ref, isString := s["$ref"].(string); isString && strings.HasPrefix(ref, "#") {
+		if tokens, err := parsePointer(strings.TrimPrefix(ref, "#")); err == nil {
+			target := ""
+			for _, token := range tokens {
+				target += "/" + pointerEscape(token)
+			}
+			result = append(result, target)
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		if subs, isArray := s[keyword].([]interface{}); isArray {
+			for i := range subs {
+				result = append(result, ptr+"/"+keyword+"/"+strconv.Itoa(i))
+			}
+		}
+	}
+	if _, exists := s["not"]; exists {
+		result = append(result, ptr+"/not")
+	}
+	return result
+}
+
+// Checks that all patterns in a schema are valid regular expressions
+func checkPatterns(schema interface{}) error {
+	switch s := schema.(type) {
+	case map[string]interface{}:
+		for keyword, child := range s {
+			switch keyword {
+			case "const", "enum", "default", "examples":
+				// Values, not schemas
+				continue
+			case "pattern":
+				if pattern, isString := child.(string); isString {
+					if _, err := compilePattern(pattern); err != nil {
+						return err
+					}
+				}
+			}
+			if err := checkPatterns(child); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range s {
+			if err := checkPatterns(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// schemaValidator validates a JSON document against a JSON schema (a
+// subset of draft 2020-12). Unknown keywords are ignored.
+type schemaValidator struct {
+	root     interface{}   // Root schema, for resolving $ref
+	errors   []schemaError // Errors found so far
+	refDepth int           // Number of nested $ref, to detect infinite recursion
+	steps    *int          // Number of validated (sub)schemas, shared with sub validators
+}
+
+// Max number of nested $ref
+const maxRefDepth = 64
+
+// Max number of (sub)schemas validated for one document. Schemas with
+// many alternatives (anyOf, oneOf) and $ref might otherwise take
+// exponential time, which would block all writes.
+const maxSchemaSteps = 200000
+
+func newSchemaValidator(root interface{}) *schemaValidator {
+	return &schemaValidator{root: root, steps: new(int)}
+}
+
+// Checks if the validation was aborted since it exceeded maxSchemaSteps
+func (v *schemaValidator) aborted() bool {
+	return *v.steps > maxSchemaSteps
+}
+
+func (v *schemaValidator) fail(ptr string, format string, args ...interface{}) {
+	v.errors = append(v.errors, schemaError{Path: ptr, Message: fmt.Sprintf(format, args...)})
+}
+
+// Checks if doc is valid without recording any errors
+func (v *schemaValidator) isValid(schema interface{}, doc interface{}) bool {
+	sub := schemaValidator{root: v.root, refDepth: v.refDepth, steps: v.steps}
+	sub.validate(schema, doc, "")
+	return len(sub.errors) == 0
+}
+
+// Returns the JSON schema type of a value
+func schemaType(doc interface{}) string {
+	switch d := doc.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		if r, err := parseNumber(d); err == nil && r.IsInt() {
+			return "integer"
+		}
+		return "number"
+	}
+}
+
+// Checks if a value has JSON schema type t
+func hasSchemaType(doc interface{}, t string) bool {
+	actual := schemaType(doc)
+	return actual == t || (t == "number" && actual == "integer")
+}
+
+// Escapes a key to be used in a JSON pointer
+func pointerEscape(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+// Returns the value of a non-negative integer keyword
+func schemaInt(schema map[string]interface{}, keyword string) (int, bool) {
+	r, err := parseNumber(schema[keyword])
+	if err != nil || !r.IsInt() {
+		return 0, false
+	}
+	return int(r.Num().Int64()), true
+}
+
+// Validates doc, located at JSON pointer ptr, against schema
+func (v *schemaValidator) validate(schema interface{}, doc interface{}, ptr string) {
+	*v.steps++
+	if v.aborted() {
+		return
+	}
+	s, isMap := schema.(map[string]interface{})
+	if !isMap {
+		if schema == false {
+			v.fail(ptr, "no value allowed")
+		}
+		return
+	}
+
+	if ref, isString := s["$ref"].(string); isString {
+		v.validateRef(ref, doc, ptr)
+	}
+
+	// Any type
+	switch t := s["type"].(type) {
+	case string:
+		if !hasSchemaType(doc, t) {
+			v.fail(ptr, "expected %s but was %s", t, schemaType(doc))
+		}
+	case []interface{}:
+		matched := false
+		var types []string
+		for _, candidate := range t {
+			name, _ := candidate.(string)
+			types = append(types, name)
+			matched = matched || hasSchemaType(doc, name)
+		}
+		if !matched {
+			v.fail(ptr, "expected %s but was %s", strings.Join(types, " or "), schemaType(doc))
+		}
+	}
+	if enum, isArray := s["enum"].([]interface{}); isArray {
+		found := false
+		for _, value := range enum {
+			found = found || jsonEqual(value, doc)
+		}
+		if !found {
+			v.fail(ptr, "value not allowed")
+		}
+	}
+	if value, exists := s["const"]; exists && !jsonEqual(value, doc) {
+		v.fail(ptr, "value not allowed")
+	}
+	if allOf, isArray := s["allOf"].([]interface{}); isArray {
+		for _, sub := range allOf {
+			v.validate(sub, doc, ptr)
+		}
+	}
+	if anyOf, isArray := s["anyOf"].([]interface{}); isArray {
+		found := false
+		for _, sub := range anyOf {
+			found = found || v.isValid(sub, doc)
+		}
+		if !found {
+			v.fail(ptr, "does not match any of the schemas in anyOf")
+		}
+	}
+	if oneOf, isArray := s["oneOf"].([]interface{}); isArray {
+		count := 0
+		for _, sub := range oneOf {
+			if v.isValid(sub, doc) {
+				count++
+			}
+		}
+		if count != 1 {
+			v.fail(ptr, "matches %d of the schemas in oneOf, expected 1", count)
+		}
+	}
+	if not, exists := s["not"]; exists && v.isValid(not, doc) {
+		v.fail(ptr, "matches the schema in not")
+	}
+
+	switch d := doc.(type) {
+	case string:
+		v.validateString(s, d, ptr)
+	case []interface{}:
+		v.validateArray(s, d, ptr)
+	case map[string]interface{}:
+		v.validateObject(s, d, ptr)
+	case nil, bool:
+	default:
+		v.validateNumber(s, d, ptr)
+	}
+}
+
+// Validates doc against the schema referenced by ref, which needs to be
+// a JSON pointer within the root schema, such as #/$defs/player
+func (v *schemaValidator) validateRef(ref string, doc interface{}, ptr string) {
+	if !strings.HasPrefix(ref, "#") {
+		v.fail(ptr, "unsupported $ref: %s", ref)
+		return
+	}
+	tokens, err := parsePointer(strings.TrimPrefix(ref, "#"))
+	var target interface{}
+	if err == nil {
+		target, err = pointerGet(v.root, tokens)
+	}
+	if err != nil || v.refDepth >= maxRefDepth {
+		v.fail(ptr, "invalid $ref: %s", ref)
+		return
+	}
+	v.refDepth++
+	v.validate(target, doc, ptr)
+	v.refDepth--
+}
+
+func (v *schemaValidator) validateString(s map[string]interface{}, doc string, ptr string) {
+	length := utf8.RuneCountInString(doc)
+	if min, ok := schemaInt(s, "minLength"); ok && length < min {
+		v.fail(ptr, "shorter than %d characters", min)
+	}
+	if max, ok := schemaInt(s, "maxLength"); ok && length > max {
+		v.fail(ptr, "longer than %d characters", max)
+	}
+	if pattern, isString := s["pattern"].(string); isString {
+		re, err := compilePattern(pattern)
+		if err == nil && !re.MatchString(doc) {
+			v.fail(ptr, "does not match pattern %s", pattern)
+		}
+	}
+}
+
+func (v *schemaValidator) validateNumber(s map[string]interface{}, doc interface{}, ptr string) {
+	value, isNumber := doc.(json.Number)
+	if !isNumber {
+		return
+	}
+	compare := func(keyword string) (int, string, bool) {
+		limit, isNumber := s[keyword].(json.Number)
+		if !isNumber {
+			return 0, "", false
+		}
+		cmp, ok := compareNumbers(string(value), string(limit))
+		return cmp, string(limit), ok
+	}
+	if cmp, min, ok := compare("minimum"); ok && cmp < 0 {
+		v.fail(ptr, "less than %s", min)
+	}
+	if cmp, max, ok := compare("maximum"); ok && cmp > 0 {
+		v.fail(ptr, "greater than %s", max)
+	}
+	if cmp, min, ok := compare("exclusiveMinimum"); ok && cmp <= 0 {
+		v.fail(ptr, "not greater than %s", min)
+	}
+	if cmp, max, ok := compare("exclusiveMaximum"); ok && cmp >= 0 {
+		v.fail(ptr, "not less than %s", max)
+	}
+	if divisor, err := parseNumber(s["multipleOf"]); err == nil && divisor.Sign() > 0 {
+		r, err := parseNumber(value)
+		if err != nil {
+			v.fail(ptr, "number out of range")
+		} else if !new(big.Rat).Quo(r, divisor).IsInt() {
+			v.fail(ptr, "not a multiple of %s", divisor.RatString())
+		}
+	}
+}
+
+func (v *schemaValidator) validateArray(s map[string]interface{}, doc []interface{}, ptr string) {
+	if min, ok := schemaInt(s, "minItems"); ok && len(doc) < min {
+		v.fail(ptr, "fewer than %d items", min)
+	}
+	if max, ok := schemaInt(s, "maxItems"); ok && len(doc) > max {
+		v.fail(ptr, "more than %d items", max)
+	}
+	if s["uniqueItems"] == true {
+	unique:
+		for i := range doc {
+			for j := i + 1; j < len(doc); j++ {
+				if jsonEqual(doc[i], doc[j]) {
+					v.fail(ptr, "items %d and %d are equal", i, j)
+					break unique
+				}
+			}
+		}
+	}
+	prefixItems, _ := s["prefixItems"].([]interface{})
+	for i, item := range doc {
+		itemPtr := ptr + "/" + strconv.Itoa(i)
+		if i < len(prefixItems) {
+			v.validate(prefixItems[i], item, itemPtr)
+		} else if items, exists := s["items"]; exists {
+			v.validate(items, item, itemPtr)
+		}
+	}
+	if contains, exists := s["contains"]; exists {
+		found := false
+		for _, item := range doc {
+			found = found || v.isValid(contains, item)
+		}
+		if !found {
+			v.fail(ptr, "no item matches the schema in contains")
+		}
+	}
+}
+
+func (v *schemaValidator) validateObject(s map[string]interface{}, doc map[string]interface{}, ptr string) {
+	if min, ok := schemaInt(s, "minProperties"); ok && len(doc) < min {
+		v.fail(ptr, "fewer than %d properties", min)
+	}
+	if max, ok := schemaInt(s, "maxProperties"); ok && len(doc) > max {
+		v.fail(ptr, "more than %d properties", max)
+	}
+	if required, isArray := s["required"].([]interface{}); isArray {
+		for _, name := range required {
+			key, _ := name.(string)
+			if _, exists := doc[key]; !exists {
+				v.fail(ptr, "missing required property %s", key)
+			}
+		}
+	}
+
+	// Validate the properties in key order to get a stable error order
+	keys := make([]string, 0, len(doc))
+	for key := range doc {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	properties, _ := s["properties"].(map[string]interface{})
+	additional, hasAdditional := s["additionalProperties"]
+	for _, key := range keys {
+		keyPtr := ptr + "/" + pointerEscape(key)
+		if propertySchema, exists := properties[key]; exists {
+			v.validate(propertySchema, doc[key], keyPtr)
+		} else if hasAdditional {
+			if additional == false {
+				v.fail(keyPtr, "property not allowed")
+			} else {
+				v.validate(additional, doc[key], keyPtr)
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Validates doc against schema and returns the errors as "path: message"
+func schemaErrors(t *testing.T, schema string, doc string) []string {
+	t.Helper()
+	s, err := decodeJSON([]byte(schema))
+	assertExpectNoErr(t, schema, err)
+	d, err := decodeJSON([]byte(doc))
+	assertExpectNoErr(t, doc, err)
+	v := newSchemaValidator(s)
+	v.validate(s, d, "")
+	var result []string
+	for _, e := range v.errors {
+		result = append(result, e.Path+": "+e.Message)
+	}
+	return result
+}
+
+func assertSchemaValid(t *testing.T, schema string, doc string) {
+	t.Helper()
+	errs := schemaErrors(t, schema, doc)
+	if len(errs) > 0 {
+		t.Fatalf("%s shall be valid according to %s but got %v", doc, schema, errs)
+	}
+}
+
+func assertSchemaInvalid(t *testing.T, schema string, doc string, expected ...string) {
+	t.Helper()
+	errs := schemaErrors(t, schema, doc)
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected errors %v for %s according to %s but got %v", expected, doc, schema, errs)
+	}
+	for i := range expected {
+		assertEqualsStr(t, doc, expected[i], errs[i])
+	}
+}
+
+func TestSchemaTypes(t *testing.T) {
+	assertSchemaValid(t, `{"type":"object"}`, `{}`)
+	assertSchemaValid(t, `{"type":"integer"}`, `3`)
+	assertSchemaValid(t, `{"type":"integer"}`, `3.0`)
+	assertSchemaValid(t, `{"type":"number"}`, `3`)
+	assertSchemaValid(t, `{"type":["string","null"]}`, `null`)
+	assertSchemaValid(t, `true`, `1`)
+	assertSchemaValid(t, `{}`, `[1]`)
+	assertSchemaInvalid(t, `{"type":"integer"}`, `3.5`, ": expected integer but was number")
+	assertSchemaInvalid(t, `{"type":["string","null"]}`, `1`, ": expected string or null but was integer")
+	assertSchemaInvalid(t, `{"type":"array"}`, `{}`, ": expected array but was object")
+	assertSchemaInvalid(t, `false`, `1`, ": no value allowed")
+}
+
+func TestSchemaValues(t *testing.T) {
+	assertSchemaValid(t, `{"enum":["x",1,{"a":[1]}]}`, `{"a":[1.0]}`)
+	assertSchemaInvalid(t, `{"enum":["x",1]}`, `2`, ": value not allowed")
+	assertSchemaValid(t, `{"const":"x"}`, `"x"`)
+	assertSchemaInvalid(t, `{"const":"x"}`, `"y"`, ": value not allowed")
+
+	assertSchemaValid(t, `{"minLength":2,"maxLength":3,"pattern":"^a"}`, `"abå"`)
+	assertSchemaInvalid(t, `{"minLength":2}`, `"a"`, ": shorter than 2 characters")
+	assertSchemaInvalid(t, `{"maxLength":2}`, `"abc"`, ": longer than 2 characters")
+	assertSchemaInvalid(t, `{"pattern":"^a"}`, `"ba"`, ": does not match pattern ^a")
+
+	assertSchemaValid(t, `{"minimum":0,"maximum":10,"multipleOf":0.5}`, `10`)
+	assertSchemaInvalid(t, `{"minimum":0}`, `-1`, ": less than 0")
+	assertSchemaInvalid(t, `{"maximum":10}`, `10.5`, ": greater than 10")
+	assertSchemaInvalid(t, `{"exclusiveMinimum":0}`, `0`, ": not greater than 0")
+	assertSchemaInvalid(t, `{"exclusiveMaximum":10}`, `10`, ": not less than 10")
+	assertSchemaInvalid(t, `{"multipleOf":0.5}`, `0.3`, ": not a multiple of 1/2")
+	assertSchemaValid(t, `{"minimum":0}`, `"not a number"`)
+	assertSchemaInvalid(t, `{"maximum":10}`, `1e1000000`, ": greater than 10")
+	assertSchemaValid(t, `{"minimum":1e999999}`, `1e1000000`)
+	assertSchemaInvalid(t, `{"multipleOf":2}`, `1e1000000`, ": number out of range")
+	assertSchemaValid(t, `{"type":"number"}`, `1e1000000`)
+}
+
+func TestSchemaArrays(t *testing.T) {
+	schema := `{"type":"array","items":{"type":"integer"},"minItems":1,"maxItems":3}`
+	assertSchemaValid(t, schema, `[1,2,3]`)
+	assertSchemaInvalid(t, schema, `[]`, ": fewer than 1 items")
+	assertSchemaInvalid(t, schema, `[1,2,3,4]`, ": more than 3 items")
+	assertSchemaInvalid(t, schema, `[1,"x"]`, "/1: expected integer but was string")
+	assertSchemaInvalid(t, `{"uniqueItems":true}`, `[1,2,1]`, ": items 0 and 2 are equal")
+	assertSchemaValid(t, `{"prefixItems":[{"type":"string"}],"items":{"type":"integer"}}`, `["a",1,2]`)
+	assertSchemaInvalid(t, `{"prefixItems":[{"type":"string"}],"items":false}`, `["a",1]`,
+		"/1: no value allowed")
+	assertSchemaValid(t, `{"contains":{"const":2}}`, `[1,2]`)
+	assertSchemaInvalid(t, `{"contains":{"const":2}}`, `[1]`, ": no item matches the schema in contains")
+}
+
+func TestSchemaObjects(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"required": ["players", "board"],
+		"properties": {
+			"players": {"type": "array", "items": {"type": "string"}},
+			"board": {"type": "array", "items": {"enum": [0, 1, 2]}}
+		},
+		"additionalProperties": false
+	}`
+	assertSchemaValid(t, schema, `{"players":["alice","bob"],"board":[0,1,2]}`)
+	assertSchemaInvalid(t, schema, `{"players":["alice",3],"board":[0,7],"turn":"a/b"}`,
+		"/board/1: value not allowed",
+		"/players/1: expected string but was integer",
+		"/turn: property not allowed")
+	assertSchemaInvalid(t, schema, `{"board":[]}`, ": missing required property players")
+	assertSchemaInvalid(t, `{"additionalProperties":{"type":"integer"}}`, `{"a/b":"x"}`,
+		"/a~1b: expected integer but was string")
+	assertSchemaInvalid(t, `{"minProperties":1}`, `{}`, ": fewer than 1 properties")
+	assertSchemaInvalid(t, `{"maxProperties":1}`, `{"a":1,"b":2}`, ": more than 1 properties")
+}
+
+func TestSchemaCombinations(t *testing.T) {
+	assertSchemaValid(t, `{"allOf":[{"type":"integer"},{"minimum":1}]}`, `1`)
+	assertSchemaInvalid(t, `{"allOf":[{"type":"integer"},{"minimum":1}]}`, `0`, ": less than 1")
+	assertSchemaValid(t, `{"anyOf":[{"type":"integer"},{"type":"string"}]}`, `"x"`)
+	assertSchemaInvalid(t, `{"anyOf":[{"type":"integer"},{"type":"string"}]}`, `null`,
+		": does not match any of the schemas in anyOf")
+	assertSchemaInvalid(t, `{"oneOf":[{"type":"integer"},{"type":"number"}]}`, `1`,
+		": matches 2 of the schemas in oneOf, expected 1")
+	assertSchemaInvalid(t, `{"not":{"type":"null"}}`, `null`, ": matches the schema in not")
+
+	defs := `{"$defs":{"player":{"type":"string","minLength":1}},"type":"array","items":{"$ref":"#/$defs/player"}}`
+	assertSchemaValid(t, defs, `["alice"]`)
+	assertSchemaInvalid(t, defs, `[""]`, "/0: shorter than 1 characters")
+	assertSchemaInvalid(t, `{"$ref":"#/$defs/missing"}`, `1`, ": invalid $ref: #/$defs/missing")
+	assertSchemaInvalid(t, `{"$ref":"other.json"}`, `1`, ": unsupported $ref: other.json")
+	errs := schemaErrors(t, `{"$ref":"#"}`, `1`)
+	assertEqualsStr(t, "Infinite recursion", ": invalid $ref: #", errs[0])
+}
+
+func TestCheckSchema(t *testing.T) {
+	for _, valid := range []string{`{}`, `true`, `{"properties":{"a":{"pattern":"^x"}}}`,
+		`{"const":{"pattern":"("}}`} {
+		s, _ := decodeJSON([]byte(valid))
+		assertExpectNoErr(t, valid, checkSchema(s))
+	}
+	for _, invalid := range []string{`1`, `[]`, `{"properties":{"a":{"pattern":"("}}}`,
+		`{"$ref":"#"}`, `{"anyOf":[{"$ref":"#"},{"$ref":"#"}]}`, `{"not":{"allOf":[{"$ref":"#/not"}]}}`,
+		`{"$defs":{"a":{"oneOf":[{"$ref":"#/$defs/b"}]},"b":{"$ref":"#/$defs/a"}}}`} {
+		s, _ := decodeJSON([]byte(invalid))
+		assertExpectErr(t, invalid, checkSchema(s))
+	}
+}
+
+func TestCompilePattern(t *testing.T) {
+	re, err := compilePattern("^a+$")
+	assertExpectNoErr(t, "", err)
+	assertTrue(t, "", re.MatchString("aaa"))
+	cached, _ := compilePattern("^a+$")
+	assertTrue(t, "", re == cached)
+	_, err = compilePattern("(")
+	assertExpectErr(t, "", err)
+}
+
+func TestSchemaSteps(t *testing.T) {
+	// Exponential number of alternatives, but each $ref consumes a level
+	// of the document, thus it is no cycle
+	schema := `{"anyOf":[{"type":"array","items":{"$ref":"#"}},{"type":"array","items":{"$ref":"#"}},{"type":"integer"}]}`
+	s, _ := decodeJSON([]byte(schema))
+	assertExpectNoErr(t, schema, checkSchema(s))
+	doc := strings.Repeat("[", 40) + `"x"` + strings.Repeat("]", 40)
+	d, _ := decodeJSON([]byte(doc))
+	start := time.Now()
+	v := newSchemaValidator(s)
+	v.validate(s, d, "")
+	assertTrue(t, "Aborted", v.aborted())
+	assertTrue(t, "Time", time.Since(start) < 5*time.Second)
+
+	// A cycle created outside waserver, i.e. without checkSchema
+	s, _ = decodeJSON([]byte(`{"anyOf":[{"$ref":"#"},{"$ref":"#"}]}`))
+	d, _ = decodeJSON([]byte(`1`))
+	v = newSchemaValidator(s)
+	v.validate(s, d, "")
+	assertTrue(t, "Aborted", v.aborted())
+}
+
+func TestSchemaOf(t *testing.T) {
+	wa := newTestWebAPI(t, nil)
+	dataDir := wa.dataPath
+	os.MkdirAll(path.Join(dataDir, "app", "games", "sub"), 0777)
+	os.MkdirAll(path.Join(dataDir, "app", "scores", "sub"), 0777)
+	os.WriteFile(path.Join(dataDir, "app", "games", schemaFile), []byte(`{"type":"object"}`), 0666)
+	os.WriteFile(path.Join(dataDir, "app", "scores", schemaFile),
+		[]byte(`{"type":"integer","x-subdirectories":true}`), 0666)
+
+	assertExpectNoErr(t, "", wa.validateSchema("app/games/1.json", []byte(`{}`)))
+	assertExpectErr(t, "", wa.validateSchema("app/games/1.json", []byte(`1`)))
+	assertExpectNoErr(t, "Not for subdirectories", wa.validateSchema("app/games/sub/1.json", []byte(`1`)))
+	assertExpectErr(t, "", wa.validateSchema("app/scores/sub/1.json", []byte(`{}`)))
+	assertExpectNoErr(t, "No schema", wa.validateSchema("app/other/1.json", []byte(`1`)))
+	assertExpectNoErr(t, "", wa.validateSchema("app/games/"+schemaFile, []byte(`{"type":"array"}`)))
+	assertExpectErr(t, "", wa.validateSchema("app/games/"+schemaFile, []byte(`3`)))
+}
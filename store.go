@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"time"
+)
+
+// Data store operations. All modifications of the data directory goes
+// through these methods, which are expected to be called with wa.mutex
+// locked.
+
+// writeOptions are options of a write of an object
+type writeOptions struct {
+	ttl  *time.Duration // Time-to-live of the object (nil = keep current)
+	user string         // Logged in user who writes the object ("" = anonymous)
+}
+
+// Parses the write options of a request
+func (wa *WebAPI) writeOptions(r *http.Request) (writeOptions, error) {
+	ttl, err := parseTTL(r)
+	if err != nil {
+		return writeOptions{}, err
+	}
+	return writeOptions{ttl: ttl, user: userOf(r)}, nil
+}
+
+// Writes object rel (for example adir/obj.json) relative the data
+// directory. The previous contents (if any) is kept in the history.
+// Writes exceeding the quota of the app are rejected.
+func (wa *WebAPI) storeObject(rel string, data []byte, opts writeOptions) error {
+	event, err := wa.writeObject(rel, data, opts)
+	if event != "" {
+		wa.modified(event, rel, data)
+	}
+	return err
+}
+
+// Same as storeObject but without informing about the modification,
+// which is up to the caller. Returns the event (create or update) once
+// the object has been written, otherwise "".
+func (wa *WebAPI) writeObject(rel string, data []byte, opts writeOptions) (string, error) {
+	fullPath := path.Join(wa.dataPath, rel)
+	err := wa.validateSchema(rel, data)
+	if err != nil {
+		return "", err
+	}
+	err = wa.checkQuota(rel, data)
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(path.Dir(fullPath), 0777)
+	if err != nil {
+		return "", err
+	}
+	info, statErr := os.Stat(fullPath)
+	isNew := statErr != nil || wa.isExpired(rel, info.ModTime())
+	previous, err := os.ReadFile(fullPath)
+	if err == nil {
+		err = wa.history.archive(rel, previous)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = writeFileAtomic(fullPath, data, 0777)
+	if err != nil {
+		return "", err
+	}
+	if statErr == nil {
+		wa.addUsage(rel, int64(len(data))-info.Size(), 0, 0)
+	} else {
+		wa.addUsage(rel, int64(len(data)), 1, 0)
+	}
+	meta := wa.readMeta(rel)
+	if isNew {
+		// New object (or expired object), thus don't keep old metadata
+		meta = objectMeta{}
+	}
+	meta.ModifiedBy = opts.user
+	if opts.ttl != nil {
+		meta.Expires = nil
+		if *opts.ttl > 0 {
+			expires := time.Now().Add(*opts.ttl).UTC()
+			meta.Expires = &expires
+		}
+	}
+	err = wa.writeMeta(rel, meta)
+	if isNew {
+		return "create", err
+	}
+	return "update", err
+}
+
+// Removes object rel relative the data directory. The removed contents
+// is kept in the history.
+func (wa *WebAPI) removeObject(rel string) error {
+	err := wa.deleteObject(rel)
+	if err != nil {
+		return err
+	}
+	wa.modified("delete", rel, nil)
+	return nil
+}
+
+// Same as removeObject but without informing about the modification,
+// which is up to the caller
+func (wa *WebAPI) deleteObject(rel string) error {
+	fullPath := path.Join(wa.dataPath, rel)
+	previous, err := os.ReadFile(fullPath)
+	if err != nil {
+		return err
+	}
+	err = wa.history.archive(rel, previous)
+	if err != nil {
+		return err
+	}
+	wa.removeMeta(rel)
+	err = os.Remove(fullPath)
+	if err != nil {
+		return err
+	}
+	wa.addUsage(rel, -int64(len(previous)), -1, 0)
+	return nil
+}
+
+// Removes directory relDir relative the data directory including all
+// its contents. All removed objects are kept in the history.
+func (wa *WebAPI) removeDirectory(relDir string) error {
+	fullDir := path.Join(wa.dataPath, relDir)
+	err := wa.history.archiveDir(fullDir, relDir)
+	if err != nil {
+		return err
+	}
+	wa.removeMeta(relDir)
+	err = os.RemoveAll(fullDir)
+	wa.resetUsage(relDir)
+	if err != nil {
+		return err
+	}
+	wa.modified("delete", relDir+"/", nil)
+	return nil
+}
+
+// Called when a modification of object rel (or directory rel ending
+// with /) has been committed. Trims the history to the quota and
+// informs the waiting GETs, the event streams and the webhooks.
+func (wa *WebAPI) modified(event string, rel string, data []byte) {
+	wa.trimHistory(rel)
+	wa.changes.notify(rel)
+	wa.events.add(event, rel, data)
+	wa.webhooks.trigger(event, rel, data)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Parses the time-to-live of a write, which is given by the X-TTL header
+// or the ttl query parameter, such as 1h30m. Returns nil if no TTL is
+// given. A TTL of 0 removes the expiry of the object.
+func parseTTL(r *http.Request) (*time.Duration, error) {
+	ttl := r.Header.Get("X-TTL")
+	if r.URL.Query().Has("ttl") {
+		ttl = r.URL.Query().Get("ttl")
+	}
+	if ttl == "" {
+		return nil, nil
+	}
+	duration, err := time.ParseDuration(ttl)
+	if err != nil || duration < 0 {
+		return nil, &statusError{http.StatusBadRequest, "Invalid TTL: " + ttl}
+	}
+	return &duration, nil
+}
+
+// Returns the TTL of objects in directory relDir according to the
+// configuration (0 = no TTL). The TTL configured for the closest parent
+// directory applies.
+func (wa *WebAPI) directoryTTL(relDir string) time.Duration {
+	for {
+		if ttl, exists := wa.config.TTL[relDir]; exists {
+			return time.Duration(ttl)
+		}
+		if relDir == "." || relDir == "" {
+			return 0
+		}
+		relDir = path.Dir(relDir)
+	}
+}
+
+// Returns the time when object rel, last modified at modTime, expires
+// (zero time if it never expires). An object expires at the time set by
+// a TTL of a write or, if not set, when it hasn't been modified within
+// the TTL of the directory. Schemas never expire.
+func (wa *WebAPI) expiryOf(rel string, modTime time.Time) time.Time {
+	if path.Base(rel) == schemaFile {
+		return time.Time{}
+	}
+	meta := wa.readMeta(rel)
+	if meta.Expires != nil {
+		return *meta.Expires
+	}
+	if ttl := wa.directoryTTL(path.Dir(rel)); ttl > 0 {
+		return modTime.Add(ttl)
+	}
+	return time.Time{}
+}
+
+// Checks if object rel, last modified at modTime, has expired
+func (wa *WebAPI) isExpired(rel string, modTime time.Time) bool {
+	expires := wa.expiryOf(rel, modTime)
+	return !expires.IsZero() && time.Now().After(expires)
+}
+
+// Returns the expiry time of the file fullPath inside the data directory
+// (zero time if it never expires)
+func (wa *WebAPI) fileExpiry(fullPath string, info fs.FileInfo) time.Time {
+	rel, err := filepath.Rel(wa.dataPath, fullPath)
+	if err != nil {
+		return time.Time{}
+	}
+	return wa.expiryOf(filepath.ToSlash(rel), info.ModTime())
+}
+
+// Checks if the file fullPath inside the data directory has expired
+func (wa *WebAPI) isFileExpired(fullPath string, info fs.FileInfo) bool {
+	expires := wa.fileExpiry(fullPath, info)
+	return !expires.IsZero() && time.Now().After(expires)
+}
+
+// Reads object rel. Expired objects are treated as if they don't exist.
+func (wa *WebAPI) readObject(rel string) ([]byte, error) {
+	fullPath := path.Join(wa.dataPath, rel)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if wa.isExpired(rel, info.ModTime()) {
+		return nil, &fs.PathError{Op: "open", Path: fullPath, Err: errors.New("object expired")}
+	}
+	return os.ReadFile(fullPath)
+}
+
+// Deletes all expired objects
+func (wa *WebAPI) sweepExpired() {
+	wa.mutex.Lock()
+	defer wa.mutex.Unlock()
+
+	// Objects with an expiry time set by a write
+	metaRoot := path.Join(wa.dataPath, metaDir)
+	var expired []string
+	filepath.WalkDir(metaRoot, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(metaRoot, name)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		info, err := os.Stat(path.Join(wa.dataPath, rel))
+		if err != nil {
+			// The object don't exist anymore
+			wa.writeMeta(rel, objectMeta{})
+		} else if wa.isExpired(rel, info.ModTime()) {
+			expired = append(expired, rel)
+		}
+		return nil
+	})
+
+	// Objects in directories with a TTL
+	for relDir := range wa.config.TTL {
+		fullDir := path.Join(wa.dataPath, relDir)
+		filepath.WalkDir(fullDir, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if strings.HasPrefix(d.Name(), ".") && name != fullDir {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			info, err := d.Info()
+			if d.IsDir() || path.Ext(name) != ".json" || err != nil {
+				return nil
+			}
+			if wa.isFileExpired(name, info) {
+				rel, _ := filepath.Rel(wa.dataPath, name)
+				expired = append(expired, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+	}
+
+	for _, rel := range expired {
+		slog.Debug("Expired " + rel)
+		err := wa.removeObject(rel)
+		if err != nil && !os.IsNotExist(err) {
+			slog.Info("Unable to delete expired object " + rel + ": " + err.Error())
+		}
+	}
+}
+
+// Runs sweepExpired at the configured interval until stop is closed
+func (wa *WebAPI) runSweeper(stop chan struct{}) {
+	interval := time.Duration(wa.config.TTLSweepInterval)
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			wa.sweepExpired()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestParseTTL(t *testing.T) {
+	r := httptest.NewRequest("POST", "/data/a/b", nil)
+	ttl, err := parseTTL(r)
+	assertExpectNoErr(t, "", err)
+	assertTrue(t, "No TTL", ttl == nil)
+
+	r = httptest.NewRequest("POST", "/data/a/b?ttl=1h30m", nil)
+	ttl, err = parseTTL(r)
+	assertExpectNoErr(t, "", err)
+	assertTrue(t, "TTL from query", ttl != nil && *ttl == 90*time.Minute)
+
+	r = httptest.NewRequest("POST", "/data/a/b", nil)
+	r.Header.Set("X-TTL", "10s")
+	ttl, err = parseTTL(r)
+	assertExpectNoErr(t, "", err)
+	assertTrue(t, "TTL from header", ttl != nil && *ttl == 10*time.Second)
+
+	r = httptest.NewRequest("POST", "/data/a/b?ttl=0s", nil)
+	ttl, err = parseTTL(r)
+	assertExpectNoErr(t, "", err)
+	assertTrue(t, "Zero TTL", ttl != nil && *ttl == 0)
+
+	for _, invalid := range []string{"abc", "-1s", "10"} {
+		r = httptest.NewRequest("POST", "/data/a/b?ttl="+invalid, nil)
+		_, err = parseTTL(r)
+		assertExpectErr(t, invalid, err)
+	}
+}
+
+func TestDirectoryTTL(t *testing.T) {
+	wa := newTestWebAPI(t, &Config{TTL: map[string]Duration{
+		"app/sessions":        Duration(time.Hour),
+		"app/sessions/guests": Duration(time.Minute),
+	}})
+	assertTrue(t, "", wa.directoryTTL("app/sessions") == time.Hour)
+	assertTrue(t, "", wa.directoryTTL("app/sessions/a/b") == time.Hour)
+	assertTrue(t, "", wa.directoryTTL("app/sessions/guests") == time.Minute)
+	assertTrue(t, "", wa.directoryTTL("app") == 0)
+	assertTrue(t, "", wa.directoryTTL(".") == 0)
+}
+
+func TestExpiry(t *testing.T) {
+	config := DefaultConfig()
+	config.TTL = map[string]Duration{"temp": Duration(time.Hour)}
+	wa := newTestWebAPI(t, config)
+	dataDir := wa.dataPath
+
+	// Object with a TTL of a write
+	ttl := time.Hour
+	err := wa.storeObject("keep/obj.json", []byte(`{}`), writeOptions{ttl: &ttl})
+	assertExpectNoErr(t, "", err)
+	assertTrue(t, "Expiry set", wa.readMeta("keep/obj.json").Expires != nil)
+	_, err = wa.readObject("keep/obj.json")
+	assertExpectNoErr(t, "", err)
+
+	// Writes without TTL keep the expiry, TTL 0 removes it
+	err = wa.storeObject("keep/obj.json", []byte(`{"a":1}`), writeOptions{})
+	assertExpectNoErr(t, "", err)
+	assertTrue(t, "Expiry kept", wa.readMeta("keep/obj.json").Expires != nil)
+	ttl = 0
+	err = wa.storeObject("keep/obj.json", []byte(`{"a":2}`), writeOptions{ttl: &ttl})
+	assertExpectNoErr(t, "", err)
+	assertTrue(t, "Expiry removed", wa.readMeta("keep/obj.json").Expires == nil)
+	assertFileNotExist(t, "", path.Join(dataDir, metaDir, "keep/obj.json"))
+
+	// Expired object
+	ttl = time.Millisecond
+	err = wa.storeObject("keep/short.json", []byte(`{}`), writeOptions{ttl: &ttl})
+	assertExpectNoErr(t, "", err)
+	time.Sleep(10 * time.Millisecond)
+	_, err = wa.readObject("keep/short.json")
+	assertExpectErr(t, "", err)
+
+	// Object in directory with TTL
+	err = wa.storeObject("temp/old.json", []byte(`{}`), writeOptions{})
+	assertExpectNoErr(t, "", err)
+	err = wa.storeObject("temp/new.json", []byte(`{}`), writeOptions{})
+	assertExpectNoErr(t, "", err)
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(path.Join(dataDir, "temp/old.json"), old, old)
+	_, err = wa.readObject("temp/old.json")
+	assertExpectErr(t, "", err)
+	_, err = wa.readObject("temp/new.json")
+	assertExpectNoErr(t, "", err)
+
+	// Sweep removes expired objects and their metadata
+	wa.sweepExpired()
+	assertFileNotExist(t, "", path.Join(dataDir, "keep/short.json"))
+	assertFileNotExist(t, "", path.Join(dataDir, metaDir, "keep/short.json"))
+	assertFileNotExist(t, "", path.Join(dataDir, "temp/old.json"))
+	assertFileExist(t, "", path.Join(dataDir, "temp/new.json"))
+	assertFileExist(t, "", path.Join(dataDir, "keep/obj.json"))
+}
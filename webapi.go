@@ -3,49 +3,98 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// Directory inside the data directory where the history is stored
+const historyDir = ".history"
+
 // WebAPI represents the REST API server.
 type WebAPI struct {
 	server      *http.Server
-	appPath     string // Path to the applications
-	dataPath    string // Path to the data
-	tlsCertFile string // TLS certification file ("" means no TLS)
-	tlsKeyFile  string // TLS key file ("" means no TLS)
+	appPath     string          // Path to the applications
+	dataPath    string          // Path to the data
+	tlsCertFile string          // TLS certification file ("" means no TLS)
+	tlsKeyFile  string          // TLS key file ("" means no TLS)
+	config      *Config         // Server configuration
+	history     *history        // Previous revisions of data objects
+	ids         idGenerator     // Generates IDs of objects posted to directories
+	mutex       sync.RWMutex    // Serializes modifications of the data (read locked by GET)
+	stop        chan struct{}   // Closed when the server is stopped
+	rooms       *rooms          // Members of the WebSocket rooms
+	changes     *changeNotifier // Notifies waiting GETs about changes
+	events      *changeLog      // Change feed of the event streams
+	usages      *usages         // Storage used by each app
+	webhooks    *webhooks       // Calls webhooks when data is modified
+	auth        *auth           // User accounts and login sessions
 }
 
 // CreateWebAPI creates a new Web API instance
 func CreateWebAPI(port int, appPath, dataPath string,
-	tlsCertFile, tlsKeyFile string) *WebAPI {
+	tlsCertFile, tlsKeyFile string, config *Config) *WebAPI {
 	portStr := fmt.Sprintf(":%d", port)
-	server := &http.Server{Addr: portStr}
+	mux := http.NewServeMux()
+	server := &http.Server{Addr: portStr, Handler: mux}
 	webAPI := &WebAPI{
 		server:      server,
 		appPath:     appPath,
 		dataPath:    dataPath,
 		tlsCertFile: tlsCertFile,
-		tlsKeyFile:  tlsKeyFile}
-	http.Handle("/app/", http.StripPrefix("/app/",
+		tlsKeyFile:  tlsKeyFile,
+		config:      config,
+		stop:        make(chan struct{}),
+		rooms:       newRooms(),
+		changes:     newChangeNotifier(),
+		events:      newChangeLog(),
+		usages:      newUsages(),
+		webhooks:    newWebhooks(path.Join(dataPath, webhooksDir), config),
+		auth:        newAuth(path.Join(dataPath, authDir), time.Duration(config.SessionMaxAge)),
+		history: newHistory(path.Join(dataPath, historyDir), config.HistoryMaxCount,
+			time.Duration(config.HistoryMaxAge))}
+	webAPI.history.sizeChanged = func(rel string, bytes int64) {
+		webAPI.addUsage(rel, 0, 0, bytes)
+	}
+	mux.Handle("/app/", http.StripPrefix("/app/",
 		http.FileServer(http.Dir(appPath))))
-	http.Handle("/", http.RedirectHandler("/app/", http.StatusSeeOther))
-	http.HandleFunc("GET /data/", webAPI.handleDataGet)
-	http.HandleFunc("POST /data/", webAPI.handleDataPost)
-	http.HandleFunc("DELETE /data/", webAPI.handleDataDelete)
-	http.HandleFunc("GET /service/apps", webAPI.handleAppsGet)
-	http.HandleFunc("POST /service/shutdown", webAPI.handleShutdown)
+	mux.Handle("/", http.RedirectHandler("/app/", http.StatusSeeOther))
+	mux.HandleFunc("GET /data/", webAPI.withUser(webAPI.handleDataGet, false))
+	mux.HandleFunc("POST /data/", webAPI.withUser(webAPI.handleDataPost, true))
+	mux.HandleFunc("DELETE /data/", webAPI.withUser(webAPI.handleDataDelete, true))
+	mux.HandleFunc("PATCH /data/", webAPI.withUser(webAPI.handleDataPatch, true))
+	mux.HandleFunc("GET /events/data/", webAPI.handleEvents)
+	mux.HandleFunc("GET /ws/{app}/{room}", webAPI.withUser(webAPI.handleWebSocket, true))
+	mux.HandleFunc("POST /service/auth/register", webAPI.handleRegister)
+	mux.HandleFunc("POST /service/auth/login", webAPI.handleLogin)
+	mux.HandleFunc("POST /service/auth/logout", webAPI.handleLogout)
+	mux.HandleFunc("GET /service/auth/user", webAPI.handleUserGet)
+	mux.HandleFunc("GET /service/apps", webAPI.handleAppsGet)
+	mux.HandleFunc("GET /service/usage", webAPI.handleUsageGet)
+	mux.HandleFunc("POST /service/batch", webAPI.withUser(webAPI.handleBatch, true))
+	mux.HandleFunc("GET /service/schema/{dir...}", webAPI.handleSchemaGet)
+	mux.HandleFunc("POST /service/schema/{dir...}", webAPI.withUser(webAPI.handleSchemaPost, true))
+	mux.HandleFunc("DELETE /service/schema/{dir...}", webAPI.withUser(webAPI.handleSchemaDelete, true))
+	mux.HandleFunc("POST /service/shutdown", webAPI.handleShutdown)
 	return webAPI
 }
 
 func (wa *WebAPI) Start() chan bool {
 	done := make(chan bool)
 
+	go wa.runSweeper(wa.stop)
+	go wa.runHistoryPruner(wa.stop)
+	go wa.webhooks.run(wa.stop)
+	go wa.auth.runSessionPruner(wa.stop)
 	go func() {
 		slog.Info(fmt.Sprintf("Serving path %s on port %s", wa.appPath, wa.server.Addr))
 		if wa.tlsCertFile != "" && wa.tlsKeyFile != "" {
@@ -67,43 +116,146 @@ func (wa *WebAPI) Start() chan bool {
 
 // Stop stops the HTTP server.
 func (wa *WebAPI) Stop() {
+	close(wa.stop)
+	wa.rooms.closeAll()
 	wa.server.Shutdown(context.Background())
 }
 
 func (wa *WebAPI) handleDataGet(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("GET " + r.URL.Path)
-	dir, file, _ := dirAndJsonFile(r.URL.Path)
-	// Tests shows that Golang server don't allow invalid paths, thus
-	// no error needs to be handled
+	if r.URL.Query().Has("wait") {
+		wa.waitForChange(w, r)
+		return
+	}
+	wa.lockedDataGet(r).writeTo(w)
+}
+
+// Runs dataGet with the data read locked, so that a GET don't see a
+// batch partly committed. The response is buffered, so that the lock is
+// not held while the response is sent to a slow client.
+func (wa *WebAPI) lockedDataGet(r *http.Request) *bufferedResponse {
+	response := newBufferedResponse()
+	wa.mutex.RLock()
+	defer wa.mutex.RUnlock()
+	wa.dataGet(response, r)
+	return response
+}
+
+func (wa *WebAPI) dataGet(w http.ResponseWriter, r *http.Request) {
+	dir, file, err := dirAndJsonFile(r.URL.Path)
+	if err != nil {
+		messageResponse(w, http.StatusForbidden, err.Error())
+		return
+	}
 	fullDir := path.Join(wa.dataPath, dir)
 	if file == "" {
-		ls, hasLs := r.URL.Query()["ls"]
-		if hasLs && ls[0] == "true" {
-			filesMap, err := listFilesMap(fullDir)
+		query := r.URL.Query()
+		page, err := parsePageOptions(query)
+		if err != nil {
+			messageResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		ls, hasLs := query["ls"]
+		if hasLs && (ls[0] == "true" || ls[0] == "detail") {
+			modTime, unchanged := wa.dirNotModifiedSince(w, r, fullDir, 0)
+			if unchanged {
+				return
+			}
+			filesMap, next, err := listFilesMapPage(fullDir, page, wa.isFileExpired)
 			if err != nil {
 				messageResponse(w, http.StatusNotFound, err.Error())
 				return
 			}
-			filesJson, _ := json.Marshal(filesMap)
+			var filesJson []byte
+			if ls[0] == "detail" {
+				filesJson, _ = json.Marshal(listFilesDetails(fullDir, filesMap, wa.isFileExpired, wa.metaOfFile))
+			} else {
+				filesJson, _ = json.Marshal(filesMap)
+			}
+			if next != "" {
+				w.Header().Set("X-Next-Cursor", next)
+			}
+			if notModified(w, r, etagOf(filesJson), modTime) {
+				return
+			}
 			writeResponseStr(w, http.StatusOK, string(filesJson))
 			return
 
 		} else {
-			jsonOfJsonsStr, err := jsonOfJsons(fullDir)
+			opts, err := parseAggregateOptions(query)
+			if err != nil {
+				messageResponse(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			opts.page = page
+			opts.skip = wa.isFileExpired
+			modTime, unchanged := wa.dirNotModifiedSince(w, r, fullDir, opts.depth)
+			if unchanged {
+				return
+			}
+			jsonOfJsonsStr, next, err := jsonOfJsonsPage(fullDir, opts)
 			if err != nil {
 				messageResponse(w, http.StatusNotFound, err.Error())
 				return
 			}
+			if next != "" {
+				w.Header().Set("X-Next-Cursor", next)
+			}
+			if notModified(w, r, etagOf([]byte(jsonOfJsonsStr)), modTime) {
+				return
+			}
 			writeResponseStr(w, http.StatusOK, jsonOfJsonsStr)
 			return
 		}
 	}
-	fullPath := path.Join(fullDir, file)
-	dat, err := os.ReadFile(fullPath)
-	if err != nil {
-		messageResponse(w, http.StatusNotFound, err.Error())
+	rel := path.Join(dir, file)
+	query := r.URL.Query()
+	if query.Get("revs") == "true" {
+		wa.writeRevisions(w, rel)
+		return
+	}
+	var dat []byte
+	var modTime time.Time // Unknown for revisions
+	if query.Has("rev") {
+		rev, err := strconv.Atoi(query.Get("rev"))
+		if err != nil {
+			messageResponse(w, http.StatusBadRequest, "Invalid revision: "+query.Get("rev"))
+			return
+		}
+		dat, err = wa.history.read(rel, rev)
+		if err != nil {
+			messageResponse(w, http.StatusNotFound, fmt.Sprintf("Revision %d not found", rev))
+			return
+		}
+	} else {
+		dat, err = wa.readObject(rel)
+		if err != nil {
+			messageResponse(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if info, err := os.Stat(path.Join(wa.dataPath, rel)); err == nil {
+			modTime = info.ModTime()
+		}
+		if user := wa.readMeta(rel).ModifiedBy; user != "" {
+			w.Header().Set("X-Modified-By", user)
+		}
+	}
+	// The ETag is always the ETag of the whole object, so that it can
+	// be used as precondition for updates of parts of the object
+	if notModified(w, r, etagOf(dat), modTime) {
 		return
 	}
+	if query.Has("ptr") {
+		dat, err = getAtPointer(dat, query.Get("ptr"))
+		if err != nil {
+			errorResponse(w, err)
+			return
+		}
+	}
+	if query.Has("fields") {
+		opts := aggregateOptions{fields: parseFields(query.Get("fields"))}
+		dat = opts.project(dat)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(dat)
@@ -111,30 +263,148 @@ func (wa *WebAPI) handleDataGet(w http.ResponseWriter, r *http.Request) {
 
 func (wa *WebAPI) handleDataPost(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("POST " + r.URL.Path)
-	dir, file, _ := dirAndJsonFile(r.URL.Path)
-	// Tests shows that Golang server don't allow invalid paths, thus
-	// no error needs to be handled
+	dir, file, err := dirAndJsonFile(r.URL.Path)
+	if err != nil {
+		messageResponse(w, http.StatusForbidden, err.Error())
+		return
+	}
 	if file == "" {
-		messageResponse(w, http.StatusForbidden, "POST to directory not allowed")
+		wa.postToDirectory(w, r, dir)
 		return
 	}
-	fullDir := path.Join(wa.dataPath, dir)
-	err := os.MkdirAll(fullDir, 0777)
+	rel := path.Join(dir, file)
+	opts, err := wa.writeOptions(r)
 	if err != nil {
-		messageResponse(w, http.StatusInternalServerError, err.Error())
+		errorResponse(w, err)
+		return
+	}
+	var body []byte
+	restore := r.URL.Query().Get("restore")
+	if restore != "" {
+		rev, err := strconv.Atoi(restore)
+		if err != nil {
+			messageResponse(w, http.StatusBadRequest, "Invalid revision: "+restore)
+			return
+		}
+		body, err = wa.history.read(rel, rev)
+		if err != nil {
+			messageResponse(w, http.StatusNotFound, fmt.Sprintf("Revision %d not found", rev))
+			return
+		}
+	} else {
+		var ok bool
+		body, ok = wa.readJSONBody(w, r)
+		if !ok {
+			return
+		}
+	}
+	wa.mutex.Lock()
+	defer wa.mutex.Unlock()
+	current, err := wa.readObject(rel)
+	exists := err == nil
+	if !ifMatchOk(r, etagOf(current), exists) {
+		messageResponse(w, http.StatusPreconditionFailed, "ETag mismatch")
+		return
+	}
+	if !ifNoneMatchOk(r, etagOf(current), exists) {
+		messageResponse(w, http.StatusPreconditionFailed, "Object already exists")
+		return
+	}
+	query := r.URL.Query()
+	if query.Has("op") {
+		wa.postOperation(w, rel, query, current, exists, body, opts)
+		return
+	}
+	if query.Has("ptr") {
+		if !exists {
+			messageResponse(w, http.StatusNotFound, "Object "+rel+" not found")
+			return
+		}
+		body, err = setAtPointer(current, query.Get("ptr"), body)
+		if err == nil {
+			err = wa.validateStored(body)
+		}
+		if err != nil {
+			errorResponse(w, err)
+			return
+		}
+	}
+	err = wa.storeObject(rel, body, opts)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	w.Header().Set("ETag", etagOf(body))
+	if restore != "" {
+		messageResponse(w, http.StatusOK, "Restored revision "+restore)
 		return
 	}
-	fullPath := path.Join(fullDir, file)
-	body, _ := io.ReadAll(r.Body)
-	os.WriteFile(fullPath, body, 0777)
 	messageResponse(w, http.StatusOK, "JSON post successfull")
 }
 
+func (wa *WebAPI) handleDataPatch(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("PATCH " + r.URL.Path)
+	dir, file, err := dirAndJsonFile(r.URL.Path)
+	if err != nil {
+		messageResponse(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if file == "" {
+		messageResponse(w, http.StatusForbidden, "PATCH of directory not allowed")
+		return
+	}
+	rel := path.Join(dir, file)
+	opts, err := wa.writeOptions(r)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	patch, ok := wa.readJSONBody(w, r)
+	if !ok {
+		return
+	}
+	wa.mutex.Lock()
+	defer wa.mutex.Unlock()
+	current, err := wa.readObject(rel)
+	exists := err == nil
+	if !ifMatchOk(r, etagOf(current), exists) {
+		messageResponse(w, http.StatusPreconditionFailed, "ETag mismatch")
+		return
+	}
+	if !ifNoneMatchOk(r, etagOf(current), exists) {
+		messageResponse(w, http.StatusPreconditionFailed, "Object already exists")
+		return
+	}
+	if !exists && strings.TrimSpace(r.Header.Get("If-None-Match")) != "*" {
+		messageResponse(w, http.StatusNotFound, "Object "+rel+" not found")
+		return
+	}
+	patched, err := applyPatch(r.Header.Get("Content-Type"), current, exists, patch)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	err = wa.validateStored(patched)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	err = wa.storeObject(rel, patched, opts)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	w.Header().Set("ETag", etagOf(patched))
+	writeResponseStr(w, http.StatusOK, string(patched))
+}
+
 func (wa *WebAPI) handleDataDelete(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("DELETE " + r.URL.Path)
-	dir, file, _ := dirAndJsonFile(r.URL.Path)
-	// Tests shows that Golang server don't allow invalid paths, thus
-	// no error needs to be handled
+	dir, file, err := dirAndJsonFile(r.URL.Path)
+	if err != nil {
+		messageResponse(w, http.StatusForbidden, err.Error())
+		return
+	}
 	fullDir := path.Join(wa.dataPath, dir)
 	fullPath := fullDir // Might be overwritten later
 	if file == "" {
@@ -145,15 +415,203 @@ func (wa *WebAPI) handleDataDelete(w http.ResponseWriter, r *http.Request) {
 	} else {
 		fullPath = path.Join(fullDir, file)
 	}
-	_, err := os.Stat(fullPath)
+	wa.mutex.Lock()
+	defer wa.mutex.Unlock()
+	if file == "" {
+		_, err = os.Stat(fullPath)
+	} else {
+		_, err = wa.readObject(path.Join(dir, file))
+	}
 	if err != nil {
 		messageResponse(w, http.StatusNotFound, err.Error())
 		return
 	}
-	os.RemoveAll(fullPath)
+	query := r.URL.Query()
+	if file != "" && query.Has("ptr") {
+		wa.deleteAtPointer(w, r, path.Join(dir, file), query.Get("ptr"))
+		return
+	}
+	if r.Header.Get("If-Match") != "" {
+		var current []byte
+		if file == "" {
+			jsonOfJsonsStr, _ := jsonOfJsons(fullDir, aggregateOptions{skip: wa.isFileExpired})
+			current = []byte(jsonOfJsonsStr)
+		} else {
+			current, _ = os.ReadFile(fullPath)
+		}
+		if !ifMatchOk(r, etagOf(current), true) {
+			messageResponse(w, http.StatusPreconditionFailed, "ETag mismatch")
+			return
+		}
+	}
+	if file == "" {
+		err = wa.removeDirectory(dir)
+	} else {
+		err = wa.removeObject(path.Join(dir, file))
+	}
+	if err != nil {
+		messageResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	messageResponse(w, http.StatusOK, "Deleted "+fullPath)
 }
 
+// Responds with 304 Not Modified, without reading the objects, if the
+// If-Modified-Since header shows that the client has the current
+// contents of directory fullDir. Returns the modification time of the
+// directory as well.
+func (wa *WebAPI) dirNotModifiedSince(w http.ResponseWriter, r *http.Request,
+	fullDir string, depth int) (time.Time, bool) {
+	modTime := latestModTime(fullDir, depth, wa.fileExpiry)
+	if !notModifiedSince(r, modTime) {
+		return modTime, false
+	}
+	w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNotModified)
+	return modTime, true
+}
+
+// Parses the query parameters of a directory aggregate GET
+func parseAggregateOptions(query url.Values) (aggregateOptions, error) {
+	opts := aggregateOptions{}
+	if query.Has("depth") {
+		depth := query.Get("depth")
+		if depth == "all" {
+			opts.depth = -1
+		} else {
+			var err error
+			opts.depth, err = strconv.Atoi(depth)
+			if err != nil || opts.depth < 0 {
+				return opts, fmt.Errorf("invalid depth: %s", depth)
+			}
+		}
+	}
+	if query.Has("fields") {
+		opts.fields = parseFields(query.Get("fields"))
+	}
+	for _, where := range query["where"] {
+		cond, err := parseCondition(where)
+		if err != nil {
+			return opts, err
+		}
+		opts.where = append(opts.where, cond)
+	}
+	return opts, nil
+}
+
+// Creates a new object with a server generated ID in directory dir. The
+// ID and URL of the new object is returned.
+func (wa *WebAPI) postToDirectory(w http.ResponseWriter, r *http.Request, dir string) {
+	opts, err := wa.writeOptions(r)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	body, ok := wa.readJSONBody(w, r)
+	if !ok {
+		return
+	}
+	wa.mutex.Lock()
+	defer wa.mutex.Unlock()
+	id := wa.ids.next()
+	for {
+		if _, err := os.Stat(path.Join(wa.dataPath, dir, id+".json")); err != nil {
+			break
+		}
+		id = wa.ids.next()
+	}
+	err = wa.storeObject(path.Join(dir, id+".json"), body, opts)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	url := "/data/" + path.Join(dir, id)
+	if dir == "." {
+		url = "/data/" + id
+	}
+	response, _ := json.Marshal(map[string]string{"id": id, "url": url})
+	w.Header().Set("Location", url)
+	w.Header().Set("ETag", etagOf(body))
+	writeResponseStr(w, http.StatusCreated, string(response))
+}
+
+// Applies the operation in the op query parameter on object rel and
+// writes the result of the operation.
+func (wa *WebAPI) postOperation(w http.ResponseWriter, rel string, query url.Values,
+	current []byte, exists bool, operand []byte, opts writeOptions) {
+	tokens, err := operationTarget(query)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	updated, result, err := applyOperation(query.Get("op"), current, exists, tokens, operand)
+	if err == nil {
+		err = wa.validateStored(updated)
+	}
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	err = wa.storeObject(rel, updated, opts)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	resultJson, _ := json.Marshal(result)
+	w.Header().Set("ETag", etagOf(updated))
+	writeResponseStr(w, http.StatusOK, string(resultJson))
+}
+
+// Deletes the value at JSON pointer ptr inside object rel
+func (wa *WebAPI) deleteAtPointer(w http.ResponseWriter, r *http.Request, rel string, ptr string) {
+	current, err := wa.readObject(rel)
+	if err != nil {
+		messageResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if !ifMatchOk(r, etagOf(current), true) {
+		messageResponse(w, http.StatusPreconditionFailed, "ETag mismatch")
+		return
+	}
+	updated, err := removeAtPointer(current, ptr)
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	err = wa.storeObject(rel, updated, writeOptions{user: userOf(r)})
+	if err != nil {
+		errorResponse(w, err)
+		return
+	}
+	w.Header().Set("ETag", etagOf(updated))
+	messageResponse(w, http.StatusOK, "Deleted "+ptr+" in "+rel)
+}
+
+// Validates that data created on the server, for example by a patch,
+// is within the configured limits.
+func (wa *WebAPI) validateStored(data []byte) error {
+	err := validateJSON(data, wa.config.MaxDepth, wa.config.MaxStringLength)
+	if err != nil {
+		return &statusError{http.StatusUnprocessableEntity, err.Error()}
+	}
+	return nil
+}
+
+// Writes the list of revisions of object rel
+func (wa *WebAPI) writeRevisions(w http.ResponseWriter, rel string) {
+	revs, err := wa.history.list(rel)
+	if err != nil {
+		messageResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, err := os.Stat(path.Join(wa.dataPath, rel)); err != nil && len(revs) == 0 {
+		messageResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	revsJson, _ := json.Marshal(revs)
+	writeResponseStr(w, http.StatusOK, string(revsJson))
+}
+
 func (wa *WebAPI) handleAppsGet(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("GET APPS")
 	filesMap, err := listFilesMap(wa.appPath)
@@ -181,6 +639,69 @@ func (wa *WebAPI) handleShutdown(w http.ResponseWriter, r *http.Request) {
 	wa.Stop()
 }
 
+// Reads the request body and validates that it is JSON within the
+// configured limits. On failure an error response is written and false
+// is returned.
+func (wa *WebAPI) readJSONBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, wa.config.MaxBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			messageResponse(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("Body exceeds %d bytes", maxBytesErr.Limit))
+		} else {
+			messageResponse(w, http.StatusBadRequest, err.Error())
+		}
+		return nil, false
+	}
+	err = validateJSON(body, wa.config.MaxDepth, wa.config.MaxStringLength)
+	if err != nil {
+		messageResponse(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return body, true
+}
+
+// statusError is an error with an associated HTTP status code
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// Writes an error response. The status code is taken from err if it
+// is a statusError, otherwise 500 Internal Server Error is used.
+func errorResponse(w http.ResponseWriter, err error) {
+	var validationErr *validationError
+	if errors.As(err, &validationErr) {
+		response, _ := json.Marshal(map[string]interface{}{
+			"message": "Schema validation failed",
+			"errors":  validationErr.errors,
+		})
+		writeResponseStr(w, http.StatusUnprocessableEntity, string(response))
+		return
+	}
+	statusErr := toStatusError(err)
+	messageResponse(w, statusErr.status, statusErr.message)
+}
+
+// Converts an error to a statusError. Errors that aren't a statusError
+// are internal server errors, except validation errors.
+func toStatusError(err error) *statusError {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr
+	}
+	var validationErr *validationError
+	if errors.As(err, &validationErr) {
+		return &statusError{http.StatusUnprocessableEntity, err.Error()}
+	}
+	return &statusError{http.StatusInternalServerError, err.Error()}
+}
+
 func writeResponseStr(w http.ResponseWriter, status int, response string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -188,8 +709,8 @@ func writeResponseStr(w http.ResponseWriter, status int, response string) {
 }
 
 func messageResponse(w http.ResponseWriter, status int, message string) {
-	jsonResponse := fmt.Sprintf(`{"message": "%s"}`, message)
-	writeResponseStr(w, status, jsonResponse)
+	jsonResponse, _ := json.Marshal(map[string]string{"message": message})
+	writeResponseStr(w, status, string(jsonResponse))
 }
 
 // Interpretets URL.path and assumes paths ending with / is
@@ -208,6 +729,19 @@ func dirAndJsonFile(urlPath string) (string, string, error) {
 		return "", "", fmt.Errorf("hacker attack. Someone tries to access: %s", dir)
 	}
 
+	// Names starting with . are reserved for internal use (such as the
+	// history)
+	for _, name := range append(strings.Split(dir, "/"), file) {
+		if strings.HasPrefix(name, ".") && name != "." {
+			return "", "", fmt.Errorf("reserved name: %s", name)
+		}
+	}
+
+	// The schema of a directory is only accessible through /service/schema/
+	if file+".json" == schemaFile {
+		return "", "", fmt.Errorf("reserved name: %s", file)
+	}
+
 	if file != "" {
 		file = file + ".json"
 	}
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"slices"
@@ -29,6 +32,32 @@ func startServer(t *testing.T) {
 	waitServer(t)
 }
 
+// startServerWithConfig starts the server using a configuration file
+// with the provided JSON contents
+func startServerWithConfig(t *testing.T, config string) {
+	t.Helper()
+
+	configFile := path.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configFile, []byte(config), 0666)
+	assertExpectNoErr(t, "", err)
+
+	// Reset flags
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"test", "-f", configFile, "app", dataPath}
+	go main()
+	waitServer(t)
+}
+
+// newTestWebAPI creates a WebAPI, which is not started, with an empty
+// data directory. The default configuration is used if config is nil.
+func newTestWebAPI(t *testing.T, config *Config) *WebAPI {
+	t.Helper()
+	if config == nil {
+		config = DefaultConfig()
+	}
+	return CreateWebAPI(0, "app", t.TempDir(), "", "", config)
+}
+
 // waitServer waits for the server to be up and running
 func waitServer(t *testing.T) {
 	t.Helper()
@@ -40,18 +69,22 @@ func waitServer(t *testing.T) {
 			// Up and running :-)
 			return
 		}
+		time.Sleep(10 * time.Millisecond)
 	}
 	t.Fatalf("Server never started")
 }
 
-// shutdownServer shuts down server and clears the serveMux
+// shutdownServer shuts down server and removes the internal data
+// directories
 func shutdownServer(t *testing.T) {
 	// No answer expected on POST shutdown (short timeout)
 	client := http.Client{Timeout: 1 * time.Second}
 	client.Post(fmt.Sprintf("%s/service/shutdown", baseURL), "", nil)
 
-	// Reset the serveMux
-	http.DefaultServeMux = new(http.ServeMux)
+	// Remove the internal data of the server, such as the history
+	for _, dir := range []string{historyDir, metaDir, webhooksDir, authDir} {
+		os.RemoveAll(path.Join(dataPath, dir))
+	}
 }
 
 func respToString(response io.ReadCloser) string {
@@ -148,6 +181,39 @@ func deleteObject(t *testing.T, path string, expectedStatus int, recv_obj interf
 	}
 }
 
+// doRequest sends a request with optional headers and returns the response.
+// The caller is responsible for closing the response body.
+func doRequest(t *testing.T, method string, path string,
+	headers map[string]string, body []byte) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/%s", baseURL, path), bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable create req for %s path %s. Reason: %s", method, path, err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to %s path %s. Reason: %s", method, path, err)
+	}
+	return resp
+}
+
+// expectStatus sends a request and checks the returned status code. The
+// response body is returned as a string.
+func expectStatus(t *testing.T, method string, path string, headers map[string]string,
+	body string, expectedStatus int) (string, http.Header) {
+	t.Helper()
+	resp := doRequest(t, method, path, headers, []byte(body))
+	respBody := respToString(resp.Body)
+	if resp.StatusCode != expectedStatus {
+		t.Fatalf("Unexpected status code for %s %s: %d (%s)",
+			method, path, resp.StatusCode, respBody)
+	}
+	return respBody, resp.Header
+}
+
 func TestStaticGet(t *testing.T) {
 	startServer(t)
 	defer shutdownServer(t)
@@ -208,6 +274,7 @@ func TestDataPost(t *testing.T) {
 	// Post object
 	filePath := path.Join(dataPath, "myjson.json")
 	os.Remove(filePath)
+	defer os.Remove(filePath)
 	send_obj := map[string]int{"foo": 1, "bar": 2}
 	var recv_obj map[string]string
 	postObject(t, "data/myjson", http.StatusOK, &recv_obj, &send_obj)
@@ -232,12 +299,16 @@ func TestDataPost(t *testing.T) {
 	// Post into subdirectories
 	filePath = path.Join(dataPath, "a/deep/dir/structure", "myjson2.json")
 	os.Remove(filePath)
+	defer os.RemoveAll(path.Join(dataPath, "a"))
 	send_obj["foo"] = 9
 	postObject(t, "data/a/deep/dir/structure/myjson2", http.StatusOK, &recv_obj, &send_obj)
 	assertFileExist(t, "", filePath)
 
-	// Post directory (not allowed)
-	postObject(t, "data/directory/", http.StatusForbidden, &recv_obj, &send_obj)
+	// Post object that can't be written (name occupied by a directory)
+	dirPath := path.Join(dataPath, "writeFail", "obj.json")
+	os.MkdirAll(dirPath, 0777)
+	defer os.RemoveAll(path.Join(dataPath, "writeFail"))
+	postObject(t, "data/writeFail/obj", http.StatusInternalServerError, &recv_obj, &send_obj)
 
 }
 
@@ -261,6 +332,19 @@ func TestDataGet(t *testing.T) {
 	getObject(t, "data/adir/?ls=true", http.StatusOK, &filesMap)
 	assertTrue(t, "", slices.Contains(filesMap["files"], "myfile.json"))
 
+	// GET directory - detailed ls query
+	var details listDetails
+	getObject(t, "data/?ls=detail", http.StatusOK, &details)
+	i := slices.IndexFunc(details.Dirs, func(d dirDetails) bool { return d.Name == "adir" })
+	assertTrue(t, "adir listed", i >= 0)
+	assertTrue(t, "", details.Dirs[i].Children > 0)
+	getObject(t, "data/adir/?ls=detail", http.StatusOK, &details)
+	i = slices.IndexFunc(details.Files, func(f fileDetails) bool { return f.Name == "myfile" })
+	assertTrue(t, "myfile listed", i >= 0)
+	_, header := expectStatus(t, "GET", "data/adir/myfile", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", header.Get("ETag"), details.Files[i].ETag)
+	assertTrue(t, "", details.Files[i].Size > 0)
+
 	// GET directory - ls query - not found
 	var resp map[string]string
 	getObject(t, "data/this/dir/dont/exist/?ls=true", http.StatusNotFound, &resp)
@@ -278,6 +362,738 @@ func TestDataGet(t *testing.T) {
 	// GET directory - not found
 	getObject(t, "data/this/dir/dont/exist/", http.StatusNotFound, &resp)
 
+	// GET directory recursive
+	os.RemoveAll(path.Join(dataPath, "depthTest"))
+	defer os.RemoveAll(path.Join(dataPath, "depthTest"))
+	expectStatus(t, "POST", "data/depthTest/a", nil, `1`, http.StatusOK)
+	expectStatus(t, "POST", "data/depthTest/sub/b", nil, `2`, http.StatusOK)
+	expectStatus(t, "POST", "data/depthTest/sub/subsub/c", nil, `3`, http.StatusOK)
+	var depth map[string]interface{}
+	getObject(t, "data/depthTest/", http.StatusOK, &depth)
+	assertEqualsInt(t, "", 1, len(depth))
+	depth = nil
+	getObject(t, "data/depthTest/?depth=1", http.StatusOK, &depth)
+	assertEqualsInt(t, "", 2, len(depth))
+	assertEqualsInt(t, "", 1, len(depth["sub"].(map[string]interface{})))
+	depth = nil
+	getObject(t, "data/depthTest/?depth=all", http.StatusOK, &depth)
+	subsub := depth["sub"].(map[string]interface{})["subsub"].(map[string]interface{})
+	assertEqualsInt(t, "", 3, int(subsub["c"].(float64)))
+	getObject(t, "data/depthTest/?depth=x", http.StatusBadRequest, &resp)
+	getObject(t, "data/depthTest/?depth=-2", http.StatusBadRequest, &resp)
+
+}
+
+func TestDataETag(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "etagTest"))
+	defer os.RemoveAll(path.Join(dataPath, "etagTest"))
+
+	// ETag returned on POST and GET shall be equal
+	_, header := expectStatus(t, "POST", "data/etagTest/game", nil, `{"turn": 1}`, http.StatusOK)
+	etag := header.Get("ETag")
+	assertTrue(t, "ETag missing", etag != "")
+	_, header = expectStatus(t, "GET", "data/etagTest/game", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", etag, header.Get("ETag"))
+
+	// Directory aggregates shall have an ETag
+	_, header = expectStatus(t, "GET", "data/etagTest/", nil, "", http.StatusOK)
+	dirEtag := header.Get("ETag")
+	assertTrue(t, "Directory ETag missing", dirEtag != "")
+
+	// Update with matching ETag
+	_, header = expectStatus(t, "POST", "data/etagTest/game", map[string]string{"If-Match": etag},
+		`{"turn": 2}`, http.StatusOK)
+	newEtag := header.Get("ETag")
+	assertTrue(t, "ETag shall change", etag != newEtag)
+
+	// Update with old ETag shall fail and leave object untouched
+	expectStatus(t, "POST", "data/etagTest/game", map[string]string{"If-Match": etag},
+		`{"turn": 3}`, http.StatusPreconditionFailed)
+	body, _ := expectStatus(t, "GET", "data/etagTest/game", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `{"turn": 2}`, body)
+
+	// If-Match on an object that don't exist shall fail
+	expectStatus(t, "POST", "data/etagTest/other", map[string]string{"If-Match": "*"},
+		`{}`, http.StatusPreconditionFailed)
+	assertFileNotExist(t, "", path.Join(dataPath, "etagTest", "other.json"))
+
+	// Delete with wrong ETag
+	expectStatus(t, "DELETE", "data/etagTest/game", map[string]string{"If-Match": etag},
+		"", http.StatusPreconditionFailed)
+	assertFileExist(t, "", path.Join(dataPath, "etagTest", "game.json"))
+
+	// Delete directory with outdated ETag
+	expectStatus(t, "DELETE", "data/etagTest/", map[string]string{"If-Match": dirEtag},
+		"", http.StatusPreconditionFailed)
+
+	// Delete with matching ETag
+	expectStatus(t, "DELETE", "data/etagTest/game", map[string]string{"If-Match": newEtag},
+		"", http.StatusOK)
+	assertFileNotExist(t, "", path.Join(dataPath, "etagTest", "game.json"))
+}
+
+func TestDataCreateOnly(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "createTest"))
+	defer os.RemoveAll(path.Join(dataPath, "createTest"))
+
+	// First claim of the name succeeds
+	createOnly := map[string]string{"If-None-Match": "*"}
+	expectStatus(t, "POST", "data/createTest/user/alice", createOnly,
+		`{"owner": 1}`, http.StatusOK)
+
+	// Second claim of the same name fails and leaves object untouched
+	expectStatus(t, "POST", "data/createTest/user/alice", createOnly,
+		`{"owner": 2}`, http.StatusPreconditionFailed)
+	body, _ := expectStatus(t, "GET", "data/createTest/user/alice", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `{"owner": 1}`, body)
+
+	// Concurrent claims, only one shall succeed
+	results := make(chan int)
+	for i := 0; i < 10; i++ {
+		go func() {
+			req, _ := http.NewRequest("POST", baseURL+"/data/createTest/user/bob",
+				strings.NewReader(`{}`))
+			req.Header.Set("If-None-Match", "*")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				results <- 0
+				return
+			}
+			resp.Body.Close()
+			results <- resp.StatusCode
+		}()
+	}
+	succeeded := 0
+	for i := 0; i < 10; i++ {
+		if <-results == http.StatusOK {
+			succeeded++
+		}
+	}
+	assertEqualsInt(t, "", 1, succeeded)
+}
+
+func TestDataPostValidation(t *testing.T) {
+	startServerWithConfig(t, `{"maxBodySize": 100, "maxDepth": 3, "maxStringLength": 10}`)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "validationTest"))
+	defer os.RemoveAll(path.Join(dataPath, "validationTest"))
+	filePath := path.Join(dataPath, "validationTest", "obj.json")
+
+	// Valid JSON within limits
+	expectStatus(t, "POST", "data/validationTest/obj", nil, `{"a": [1, 2]}`, http.StatusOK)
+
+	// Invalid JSON shall be rejected with location and not touch the file
+	body, _ := expectStatus(t, "POST", "data/validationTest/obj", nil, "{\n\"a\": }",
+		http.StatusBadRequest)
+	assertTrue(t, body, strings.Contains(body, "line 2"))
+	dat, _ := os.ReadFile(filePath)
+	assertEqualsStr(t, "", `{"a": [1, 2]}`, string(dat))
+
+	// Empty body
+	expectStatus(t, "POST", "data/validationTest/obj", nil, "", http.StatusBadRequest)
+
+	// Too deep
+	expectStatus(t, "POST", "data/validationTest/obj", nil, `[[[[1]]]]`, http.StatusBadRequest)
+
+	// Too long string
+	expectStatus(t, "POST", "data/validationTest/obj", nil, `"abcdefghijk"`, http.StatusBadRequest)
+
+	// Too large body
+	expectStatus(t, "POST", "data/validationTest/obj", nil, "["+strings.Repeat("1,", 60)+"1]",
+		http.StatusRequestEntityTooLarge)
+
+	dat, _ = os.ReadFile(filePath)
+	assertEqualsStr(t, "", `{"a": [1, 2]}`, string(dat))
+}
+
+func TestDataHistory(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "historyTest"))
+	os.RemoveAll(path.Join(dataPath, ".history", "historyTest"))
+	defer os.RemoveAll(path.Join(dataPath, "historyTest"))
+	defer os.RemoveAll(path.Join(dataPath, ".history", "historyTest"))
+
+	// Object without revisions
+	expectStatus(t, "POST", "data/historyTest/shots", nil, `[1]`, http.StatusOK)
+	var revs []map[string]interface{}
+	getObject(t, "data/historyTest/shots?revs=true", http.StatusOK, &revs)
+	assertEqualsInt(t, "", 0, len(revs))
+
+	// Each update keeps the previous contents
+	expectStatus(t, "POST", "data/historyTest/shots", nil, `[1,2]`, http.StatusOK)
+	expectStatus(t, "POST", "data/historyTest/shots", nil, `[]`, http.StatusOK)
+	getObject(t, "data/historyTest/shots?revs=true", http.StatusOK, &revs)
+	assertEqualsInt(t, "", 2, len(revs))
+	assertEqualsInt(t, "", 1, int(revs[0]["rev"].(float64)))
+	assertEqualsInt(t, "", 2, int(revs[1]["rev"].(float64)))
+	_, hasTime := revs[0]["time"]
+	assertTrue(t, "", hasTime)
+
+	body, _ := expectStatus(t, "GET", "data/historyTest/shots?rev=2", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `[1,2]`, body)
+	expectStatus(t, "GET", "data/historyTest/shots?rev=9", nil, "", http.StatusNotFound)
+	expectStatus(t, "GET", "data/historyTest/shots?rev=x", nil, "", http.StatusBadRequest)
+
+	// Restore revision 2
+	expectStatus(t, "POST", "data/historyTest/shots?restore=2", nil, "", http.StatusOK)
+	body, _ = expectStatus(t, "GET", "data/historyTest/shots", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `[1,2]`, body)
+	expectStatus(t, "POST", "data/historyTest/shots?restore=9", nil, "", http.StatusNotFound)
+
+	// Delete keeps the contents and object can be restored
+	expectStatus(t, "DELETE", "data/historyTest/shots", nil, "", http.StatusOK)
+	getObject(t, "data/historyTest/shots?revs=true", http.StatusOK, &revs)
+	assertEqualsInt(t, "", 4, len(revs))
+	expectStatus(t, "POST", "data/historyTest/shots?restore=4", nil, "", http.StatusOK)
+	body, _ = expectStatus(t, "GET", "data/historyTest/shots", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `[1,2]`, body)
+
+	// Deleting a directory keeps the contents of its objects
+	expectStatus(t, "POST", "data/historyTest/sub/obj", nil, `{"a":1}`, http.StatusOK)
+	expectStatus(t, "DELETE", "data/historyTest/", nil, "", http.StatusOK)
+	body, _ = expectStatus(t, "GET", "data/historyTest/sub/obj?rev=1", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `{"a":1}`, body)
+
+	// No revisions of an object that never existed
+	expectStatus(t, "GET", "data/historyTest/never?revs=true", nil, "", http.StatusNotFound)
+
+	// History is not accessible or visible as data
+	expectStatus(t, "GET", "data/.history/historyTest/shots/1", nil, "", http.StatusForbidden)
+	expectStatus(t, "POST", "data/.history/x", nil, `{}`, http.StatusForbidden)
+	var filesMap map[string][]string
+	getObject(t, "data/?ls=true", http.StatusOK, &filesMap)
+	assertFalse(t, "", slices.Contains(filesMap["dirs"], ".history"))
+}
+
+func TestDataPatchMerge(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "patchTest"))
+	defer os.RemoveAll(path.Join(dataPath, "patchTest"))
+	mergePatch := map[string]string{"Content-Type": "application/merge-patch+json"}
+
+	expectStatus(t, "POST", "data/patchTest/game", nil,
+		`{"turn": 1, "board": [0, 0], "status": {"winner": null, "open": true}}`, http.StatusOK)
+
+	// Patch returns merged result
+	body, header := expectStatus(t, "PATCH", "data/patchTest/game", mergePatch,
+		`{"turn": 2, "status": {"open": null}}`, http.StatusOK)
+	var m map[string]interface{}
+	err := json.Unmarshal([]byte(body), &m)
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 2, int(m["turn"].(float64)))
+	assertEqualsInt(t, "", 2, len(m["board"].([]interface{})))
+	_, hasOpen := m["status"].(map[string]interface{})["open"]
+	assertFalse(t, "", hasOpen)
+	etag := header.Get("ETag")
+
+	// Stored object is the merged result
+	storedBody, header := expectStatus(t, "GET", "data/patchTest/game", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", body, storedBody)
+	assertEqualsStr(t, "", etag, header.Get("ETag"))
+
+	// Preconditions
+	mergePatch["If-Match"] = `"outdated"`
+	expectStatus(t, "PATCH", "data/patchTest/game", mergePatch, `{"turn": 3}`,
+		http.StatusPreconditionFailed)
+	mergePatch["If-Match"] = etag
+	expectStatus(t, "PATCH", "data/patchTest/game", mergePatch, `{"turn": 3}`, http.StatusOK)
+	delete(mergePatch, "If-Match")
+
+	// Patch creates object that don't exist only with If-None-Match: *
+	expectStatus(t, "PATCH", "data/patchTest/new", mergePatch, `{"a": 1}`, http.StatusNotFound)
+	assertFileNotExist(t, "", path.Join(dataPath, "patchTest", "new.json"))
+	mergePatch["If-None-Match"] = "*"
+	expectStatus(t, "PATCH", "data/patchTest/new", mergePatch, `{"a": 1}`, http.StatusOK)
+	body, _ = expectStatus(t, "GET", "data/patchTest/new", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `{"a":1}`, body)
+	expectStatus(t, "PATCH", "data/patchTest/new", mergePatch, `{"a": 2}`, http.StatusPreconditionFailed)
+	delete(mergePatch, "If-None-Match")
+
+	// Errors
+	expectStatus(t, "PATCH", "data/patchTest/game", map[string]string{"Content-Type": "text/plain"},
+		`{"turn": 4}`, http.StatusUnsupportedMediaType)
+	expectStatus(t, "PATCH", "data/patchTest/game", mergePatch, `{"turn": `, http.StatusBadRequest)
+	expectStatus(t, "PATCH", "data/patchTest/", mergePatch, `{}`, http.StatusForbidden)
+}
+
+func TestDataPatchJSON(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "jsonPatchTest"))
+	defer os.RemoveAll(path.Join(dataPath, "jsonPatchTest"))
+	jsonPatch := map[string]string{"Content-Type": "application/json-patch+json"}
+
+	expectStatus(t, "POST", "data/jsonPatchTest/game", nil, `{"turn":"x","moves":[]}`, http.StatusOK)
+
+	// Make a move if it is x's turn
+	body, _ := expectStatus(t, "PATCH", "data/jsonPatchTest/game", jsonPatch,
+		`[{"op":"test","path":"/turn","value":"x"},
+		  {"op":"replace","path":"/turn","value":"o"},
+		  {"op":"add","path":"/moves/-","value":4}]`, http.StatusOK)
+	assertEqualsStr(t, "", `{"moves":[4],"turn":"o"}`, body)
+
+	// Make another move as x shall fail and leave object untouched
+	expectStatus(t, "PATCH", "data/jsonPatchTest/game", jsonPatch,
+		`[{"op":"add","path":"/moves/-","value":5},
+		  {"op":"test","path":"/turn","value":"x"},
+		  {"op":"replace","path":"/turn","value":"o"}]`, http.StatusConflict)
+	body, _ = expectStatus(t, "GET", "data/jsonPatchTest/game", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `{"moves":[4],"turn":"o"}`, body)
+
+	// Invalid patch
+	expectStatus(t, "PATCH", "data/jsonPatchTest/game", jsonPatch, `{"op":"add"}`,
+		http.StatusBadRequest)
+}
+
+func TestDataPointer(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "pointerTest"))
+	defer os.RemoveAll(path.Join(dataPath, "pointerTest"))
+
+	expectStatus(t, "POST", "data/pointerTest/data", nil,
+		`{"clubs":[{"name":"7i","distance":120},{"name":"D","distance":200}]}`, http.StatusOK)
+
+	// Get nested value
+	body, header := expectStatus(t, "GET", "data/pointerTest/data?ptr=/clubs/1/distance", nil, "",
+		http.StatusOK)
+	assertEqualsStr(t, "", "200", body)
+	etag := header.Get("ETag")
+	body, _ = expectStatus(t, "GET", "data/pointerTest/data?ptr=/clubs/0", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `{"distance":120,"name":"7i"}`, body)
+
+	// Pointers that don't resolve
+	expectStatus(t, "GET", "data/pointerTest/data?ptr=/clubs/2/distance", nil, "", http.StatusNotFound)
+	expectStatus(t, "GET", "data/pointerTest/data?ptr=/balls", nil, "", http.StatusNotFound)
+	expectStatus(t, "GET", "data/pointerTest/nodata?ptr=/clubs", nil, "", http.StatusNotFound)
+	expectStatus(t, "GET", "data/pointerTest/data?ptr=clubs", nil, "", http.StatusBadRequest)
+
+	// Replace nested value
+	expectStatus(t, "POST", "data/pointerTest/data?ptr=/clubs/1/distance", map[string]string{
+		"If-Match": etag}, `210`, http.StatusOK)
+	body, _ = expectStatus(t, "GET", "data/pointerTest/data?ptr=/clubs/1/distance", nil, "",
+		http.StatusOK)
+	assertEqualsStr(t, "", "210", body)
+	expectStatus(t, "POST", "data/pointerTest/data?ptr=/clubs/1/distance", map[string]string{
+		"If-Match": etag}, `220`, http.StatusPreconditionFailed)
+
+	// Add new values
+	expectStatus(t, "POST", "data/pointerTest/data?ptr=/clubs/0/loft", nil, `34`, http.StatusOK)
+	expectStatus(t, "POST", "data/pointerTest/data?ptr=/clubs/-", nil, `{"name":"P"}`, http.StatusOK)
+	body, _ = expectStatus(t, "GET", "data/pointerTest/data", nil, "", http.StatusOK)
+	assertEqualsStr(t, "",
+		`{"clubs":[{"distance":120,"loft":34,"name":"7i"},{"distance":210,"name":"D"},{"name":"P"}]}`,
+		body)
+	expectStatus(t, "POST", "data/pointerTest/data?ptr=/balls/0", nil, `1`, http.StatusNotFound)
+	expectStatus(t, "POST", "data/pointerTest/nodata?ptr=/a", nil, `1`, http.StatusNotFound)
+
+	// Delete nested value
+	expectStatus(t, "DELETE", "data/pointerTest/data?ptr=/clubs/0", nil, "", http.StatusOK)
+	body, _ = expectStatus(t, "GET", "data/pointerTest/data?ptr=/clubs/0/name", nil, "",
+		http.StatusOK)
+	assertEqualsStr(t, "", `"D"`, body)
+	expectStatus(t, "DELETE", "data/pointerTest/data?ptr=/clubs/5", nil, "", http.StatusNotFound)
+	expectStatus(t, "DELETE", "data/pointerTest/data?ptr=", nil, "", http.StatusBadRequest)
+	assertFileExist(t, "", path.Join(dataPath, "pointerTest", "data.json"))
+}
+
+func TestDataGetWhere(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "whereTest"))
+	defer os.RemoveAll(path.Join(dataPath, "whereTest"))
+	expectStatus(t, "POST", "data/whereTest/game/1", nil,
+		`{"players":["alice","bob"],"status":"open"}`, http.StatusOK)
+	expectStatus(t, "POST", "data/whereTest/game/2", nil,
+		`{"players":["carol","bob"],"status":"done"}`, http.StatusOK)
+	expectStatus(t, "POST", "data/whereTest/game/3", nil,
+		`{"players":["alice","carol"],"status":"done"}`, http.StatusOK)
+
+	var m map[string]interface{}
+	getObject(t, "data/whereTest/game/?where=players%20contains%20alice", http.StatusOK, &m)
+	assertEqualsInt(t, "", 2, len(m))
+	_, hasKey := m["2"]
+	assertFalse(t, "", hasKey)
+
+	m = nil
+	getObject(t, "data/whereTest/game/?where=players+contains+alice&where=status%3Ddone",
+		http.StatusOK, &m)
+	assertEqualsInt(t, "", 1, len(m))
+	_, hasKey = m["3"]
+	assertTrue(t, "", hasKey)
+
+	m = nil
+	getObject(t, "data/whereTest/game/?where=status%3Dnone", http.StatusOK, &m)
+	assertEqualsInt(t, "", 0, len(m))
+
+	getObject(t, "data/whereTest/game/?where=status", http.StatusBadRequest, &m)
+}
+
+func TestDataGetPage(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "pageTest"))
+	defer os.RemoveAll(path.Join(dataPath, "pageTest"))
+	scores := []int{5, 12, 7, 12, 1}
+	for i, score := range scores {
+		expectStatus(t, "POST", fmt.Sprintf("data/pageTest/score%d", i), nil,
+			fmt.Sprintf(`{"score": %d}`, score), http.StatusOK)
+	}
+
+	// Page through aggregate sorted on score, highest first
+	var order []string
+	query := "data/pageTest/?sort=score&order=desc&limit=2"
+	for pages := 0; pages < 10; pages++ {
+		body, header := expectStatus(t, "GET", query, nil, "", http.StatusOK)
+		var m map[string]interface{}
+		err := json.Unmarshal([]byte(body), &m)
+		assertExpectNoErr(t, "", err)
+		assertTrue(t, body, len(m) <= 2)
+		// Keep order of the keys in the response
+		dec := json.NewDecoder(strings.NewReader(body))
+		dec.Token()
+		for dec.More() {
+			key, _ := dec.Token()
+			order = append(order, key.(string))
+			var value interface{}
+			dec.Decode(&value)
+		}
+		next := header.Get("X-Next-Cursor")
+		if next == "" {
+			break
+		}
+		query = "data/pageTest/?sort=score&order=desc&limit=2&cursor=" + next
+	}
+	assertEqualsStr(t, "", "score3,score1,score2,score0,score4", strings.Join(order, ","))
+
+	// ls sorted on name, descending
+	var filesMap map[string][]string
+	_, header := expectStatus(t, "GET", "data/pageTest/?ls=true&order=desc&limit=3", nil, "",
+		http.StatusOK)
+	getObject(t, "data/pageTest/?ls=true&order=desc&limit=3", http.StatusOK, &filesMap)
+	assertEqualsStr(t, "", "score4.json,score3.json,score2.json", strings.Join(filesMap["files"], ","))
+	filesMap = nil
+	getObject(t, "data/pageTest/?ls=true&order=desc&limit=3&cursor="+header.Get("X-Next-Cursor"),
+		http.StatusOK, &filesMap)
+	assertEqualsStr(t, "", "score1.json,score0.json", strings.Join(filesMap["files"], ","))
+
+	// ls sorted on modification time
+	os.Chtimes(path.Join(dataPath, "pageTest", "score2.json"), time.Now().Add(time.Hour),
+		time.Now().Add(time.Hour))
+	filesMap = nil
+	getObject(t, "data/pageTest/?ls=true&sort=mtime&order=desc&limit=1", http.StatusOK, &filesMap)
+	assertEqualsStr(t, "", "score2.json", strings.Join(filesMap["files"], ","))
+
+	var resp map[string]string
+	getObject(t, "data/pageTest/?limit=x", http.StatusBadRequest, &resp)
+	getObject(t, "data/pageTest/?ls=true&cursor=x", http.StatusBadRequest, &resp)
+}
+
+func TestDataGetFields(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "fieldsTest"))
+	defer os.RemoveAll(path.Join(dataPath, "fieldsTest"))
+	expectStatus(t, "POST", "data/fieldsTest/game/1", nil,
+		`{"players":["alice","bob"],"status":"open","board":[0,0,1],"meta":{"turn":3,"x":1}}`,
+		http.StatusOK)
+	expectStatus(t, "POST", "data/fieldsTest/game/2", nil,
+		`{"players":["carol","bob"],"status":"done","board":[1,1,1]}`, http.StatusOK)
+
+	// Single object
+	body, _ := expectStatus(t, "GET", "data/fieldsTest/game/1?fields=players,meta.turn", nil, "",
+		http.StatusOK)
+	assertEqualsStr(t, "", `{"meta":{"turn":3},"players":["alice","bob"]}`, body)
+
+	// Directory aggregate combined with where
+	var m map[string]map[string]interface{}
+	getObject(t, "data/fieldsTest/game/?fields=status&where=players+contains+bob", http.StatusOK, &m)
+	assertEqualsInt(t, "", 2, len(m))
+	assertEqualsInt(t, "", 1, len(m["1"]))
+	assertEqualsStr(t, "", "done", m["2"]["status"].(string))
+
+	// Recursive aggregate
+	var m2 map[string]map[string]map[string]interface{}
+	getObject(t, "data/fieldsTest/?depth=1&fields=status", http.StatusOK, &m2)
+	assertEqualsInt(t, "", 1, len(m2["game"]["1"]))
+}
+
+func TestDataAppend(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "appendTest"))
+	defer os.RemoveAll(path.Join(dataPath, "appendTest"))
+
+	// Append creates array
+	body, _ := expectStatus(t, "POST", "data/appendTest/shots?op=append", nil, `[{"d":100}]`,
+		http.StatusOK)
+	assertEqualsStr(t, "", `{"length":1}`, body)
+
+	// Concurrent appends shall not lose any elements
+	results := make(chan int)
+	for i := 0; i < 10; i++ {
+		go func(i int) {
+			resp, err := http.Post(baseURL+"/data/appendTest/shots?op=append", "application/json",
+				strings.NewReader(fmt.Sprintf(`[{"d":%d},{"d":%d}]`, i, i)))
+			if err != nil {
+				results <- 0
+				return
+			}
+			resp.Body.Close()
+			results <- resp.StatusCode
+		}(i)
+	}
+	for i := 0; i < 10; i++ {
+		assertEqualsInt(t, "", http.StatusOK, <-results)
+	}
+	var shots []interface{}
+	getObject(t, "data/appendTest/shots", http.StatusOK, &shots)
+	assertEqualsInt(t, "", 21, len(shots))
+
+	// Pointer addressed array
+	expectStatus(t, "POST", "data/appendTest/round", nil, `{"course":"x"}`, http.StatusOK)
+	body, _ = expectStatus(t, "POST", "data/appendTest/round?op=append&ptr=/shots", nil, `[1,2]`,
+		http.StatusOK)
+	assertEqualsStr(t, "", `{"length":2}`, body)
+	body, _ = expectStatus(t, "GET", "data/appendTest/round", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `{"course":"x","shots":[1,2]}`, body)
+
+	// Not an array
+	expectStatus(t, "POST", "data/appendTest/round?op=append&ptr=/course", nil, `[1]`,
+		http.StatusConflict)
+	expectStatus(t, "POST", "data/appendTest/round?op=append&ptr=course", nil, `[1]`,
+		http.StatusBadRequest)
+	expectStatus(t, "POST", "data/appendTest/round?op=invalid", nil, `[1]`, http.StatusBadRequest)
+}
+
+func TestDataIncr(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "incrTest"))
+	defer os.RemoveAll(path.Join(dataPath, "incrTest"))
+
+	// Concurrent increments shall not lose any increments
+	results := make(chan int)
+	for i := 0; i < 20; i++ {
+		go func() {
+			resp, err := http.Post(baseURL+"/data/incrTest/stats?op=incr&field=wins",
+				"application/json", strings.NewReader(`1`))
+			if err != nil {
+				results <- 0
+				return
+			}
+			resp.Body.Close()
+			results <- resp.StatusCode
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		assertEqualsInt(t, "", http.StatusOK, <-results)
+	}
+	body, _ := expectStatus(t, "POST", "data/incrTest/stats?op=decr&field=wins", nil, `2`,
+		http.StatusOK)
+	assertEqualsStr(t, "", `{"value":18}`, body)
+
+	// Pointer addressed
+	expectStatus(t, "POST", "data/incrTest/stats?ptr=/players", nil, `{"alice":{"losses":1}}`,
+		http.StatusOK)
+	body, _ = expectStatus(t, "POST", "data/incrTest/stats?op=incr&ptr=/players/alice/losses", nil,
+		`1`, http.StatusOK)
+	assertEqualsStr(t, "", `{"value":2}`, body)
+
+	expectStatus(t, "POST", "data/incrTest/stats?op=incr&field=players", nil, `1`,
+		http.StatusConflict)
+}
+
+func TestBatch(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "batchTest"))
+	defer os.RemoveAll(path.Join(dataPath, "batchTest"))
+	expectStatus(t, "POST", "data/batchTest/game-invites/alice", nil, `{"from":"bob"}`,
+		http.StatusOK)
+	_, header := expectStatus(t, "GET", "data/batchTest/game-invites/alice", nil, "", http.StatusOK)
+	inviteEtag := header.Get("ETag")
+
+	type result struct {
+		Status  int             `json:"status"`
+		ETag    string          `json:"etag"`
+		Body    json.RawMessage `json:"body"`
+		Message string          `json:"message"`
+	}
+	type response struct {
+		Message string   `json:"message"`
+		Results []result `json:"results"`
+	}
+	batch := func(ops string, expectedStatus int) response {
+		t.Helper()
+		body, _ := expectStatus(t, "POST", "service/batch", nil, `{"ops":`+ops+`}`, expectedStatus)
+		var resp response
+		err := json.Unmarshal([]byte(body), &resp)
+		assertExpectNoErr(t, body, err)
+		return resp
+	}
+
+	// Start a game, i.e. delete invite and create game
+	resp := batch(`[
+		{"op":"delete","path":"batchTest/game-invites/alice","ifMatch":`+jsonQuote(inviteEtag)+`},
+		{"op":"put","path":"/data/batchTest/game/1","body":{"players":["alice","bob"]},"ifNoneMatch":"*"},
+		{"op":"patch","path":"batchTest/game/1","body":{"turn":"alice"}},
+		{"op":"get","path":"batchTest/game/1"}
+	]`, http.StatusOK)
+	assertEqualsInt(t, "", 4, len(resp.Results))
+	assertEqualsStr(t, "", `{"players":["alice","bob"],"turn":"alice"}`, string(resp.Results[3].Body))
+	assertEqualsStr(t, "", resp.Results[2].ETag, resp.Results[3].ETag)
+	assertFileNotExist(t, "", path.Join(dataPath, "batchTest", "game-invites", "alice.json"))
+	body, header := expectStatus(t, "GET", "data/batchTest/game/1", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `{"players":["alice","bob"],"turn":"alice"}`, body)
+	assertEqualsStr(t, "", resp.Results[3].ETag, header.Get("ETag"))
+
+	// Failing precondition leaves everything untouched
+	resp = batch(`[
+		{"op":"put","path":"batchTest/game-invites/carol","body":{"from":"bob"}},
+		{"op":"put","path":"batchTest/game/1","body":{},"ifNoneMatch":"*"}
+	]`, http.StatusPreconditionFailed)
+	assertEqualsInt(t, "", 2, len(resp.Results))
+	assertEqualsInt(t, "", http.StatusPreconditionFailed, resp.Results[1].Status)
+	assertFileNotExist(t, "", path.Join(dataPath, "batchTest", "game-invites", "carol.json"))
+
+	// Failing get, delete and patch
+	batch(`[{"op":"put","path":"batchTest/x","body":1},{"op":"get","path":"batchTest/y"}]`,
+		http.StatusNotFound)
+	batch(`[{"op":"put","path":"batchTest/x","body":1},{"op":"delete","path":"batchTest/y"}]`,
+		http.StatusNotFound)
+	batch(`[{"op":"put","path":"batchTest/x","body":1},{"op":"patch","path":"batchTest/game/1",
+		"contentType":"application/json-patch+json","body":[{"op":"test","path":"/turn","value":"bob"}]}]`,
+		http.StatusConflict)
+	batch(`[{"op":"put","path":"batchTest/x","body":1},{"op":"patch","path":"batchTest/y","body":{}}]`,
+		http.StatusNotFound)
+	assertFileNotExist(t, "", path.Join(dataPath, "batchTest", "x.json"))
+
+	// Failing commit is rolled back
+	os.MkdirAll(path.Join(dataPath, "batchTest", "occupied.json"), 0777)
+	revs, _ := expectStatus(t, "GET", "data/batchTest/game/1?revs=true", nil, "", http.StatusOK)
+	resp = batch(`[
+		{"op":"get","path":"batchTest/game/1"},
+		{"op":"put","path":"batchTest/game/1","body":{"rolled":"back"},"ttl":"1ms"},
+		{"op":"put","path":"batchTest/newdir/sub/1","body":{}},
+		{"op":"put","path":"batchTest/occupied","body":{}},
+		{"op":"get","path":"batchTest/game/1"}
+	]`, http.StatusInternalServerError)
+	assertEqualsInt(t, "", 5, len(resp.Results))
+	assertEqualsInt(t, "Get before the batch", http.StatusOK, resp.Results[0].Status)
+	assertEqualsStr(t, "", `{"players":["alice","bob"],"turn":"alice"}`, string(resp.Results[0].Body))
+	assertEqualsInt(t, "", http.StatusFailedDependency, resp.Results[1].Status)
+	assertEqualsStr(t, "", "Rolled back", resp.Results[1].Message)
+	assertEqualsStr(t, "", "", resp.Results[1].ETag)
+	assertEqualsInt(t, "", http.StatusFailedDependency, resp.Results[2].Status)
+	assertEqualsInt(t, "", http.StatusInternalServerError, resp.Results[3].Status)
+	assertEqualsStr(t, "", resp.Message, resp.Results[3].Message)
+	assertEqualsInt(t, "Get within the batch", http.StatusFailedDependency, resp.Results[4].Status)
+	assertFileNotExist(t, "Created directory removed", path.Join(dataPath, "batchTest", "newdir"))
+	time.Sleep(10 * time.Millisecond)
+	body, _ = expectStatus(t, "GET", "data/batchTest/game/1", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `{"players":["alice","bob"],"turn":"alice"}`, body)
+	body, _ = expectStatus(t, "GET", "data/batchTest/game/1?revs=true", nil, "", http.StatusOK)
+	assertEqualsStr(t, "No revisions added", revs, body)
+	assertFileExist(t, "", path.Join(dataPath, "batchTest", "occupied.json"))
+
+	// Invalid batches
+	batch(`[{"op":"invalid","path":"batchTest/x"}]`, http.StatusBadRequest)
+	batch(`[{"op":"get","path":"batchTest/"}]`, http.StatusBadRequest)
+	batch(`[{"op":"put","path":"batchTest/x"}]`, http.StatusBadRequest)
+	expectStatus(t, "POST", "service/batch", nil, `{"ops":1}`, http.StatusBadRequest)
+}
+
+func jsonQuote(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+func TestBatchAtomicRead(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "batchReadTest"))
+	defer os.RemoveAll(path.Join(dataPath, "batchReadTest"))
+	expectStatus(t, "POST", "data/batchReadTest/a", nil, `0`, http.StatusOK)
+	expectStatus(t, "POST", "data/batchReadTest/b", nil, `0`, http.StatusOK)
+
+	// Readers shall see all or none of the objects of a batch updated
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 50; i++ {
+			resp, err := http.Post(baseURL+"/service/batch", "application/json",
+				strings.NewReader(fmt.Sprintf(`{"ops":[{"op":"put","path":"batchReadTest/a","body":%d},`+
+					`{"op":"put","path":"batchReadTest/b","body":%d}]}`, i, i)))
+			if err == nil {
+				resp.Body.Close()
+			}
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		var objects map[string]interface{}
+		getObject(t, "data/batchReadTest/?depth=all", http.StatusOK, &objects)
+		assertEqualsStr(t, "", fmt.Sprint(objects["a"]), fmt.Sprint(objects["b"]))
+	}
+	var objects map[string]interface{}
+	getObject(t, "data/batchReadTest/?depth=all", http.StatusOK, &objects)
+	assertEqualsStr(t, "", "50", fmt.Sprint(objects["a"]))
+}
+
+func TestDataPostToDirectory(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "collectionTest"))
+	defer os.RemoveAll(path.Join(dataPath, "collectionTest"))
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		var created map[string]string
+		postObject(t, "data/collectionTest/scores/", http.StatusCreated, &created,
+			map[string]int{"score": i})
+		id := created["id"]
+		assertEqualsStr(t, "", "/data/collectionTest/scores/"+id, created["url"])
+		assertFileExist(t, "", path.Join(dataPath, "collectionTest", "scores", id+".json"))
+		ids = append(ids, id)
+	}
+	assertTrue(t, "IDs shall be sortable on creation time", slices.IsSorted(ids))
+
+	// Location header refers to the new object
+	resp := doRequest(t, "POST", "data/collectionTest/scores/", nil, []byte(`{"score":9}`))
+	resp.Body.Close()
+	assertEqualsInt(t, "", http.StatusCreated, resp.StatusCode)
+	var m map[string]int
+	getObject(t, strings.TrimPrefix(resp.Header.Get("Location"), "/"), http.StatusOK, &m)
+	assertEqualsInt(t, "", 9, m["score"])
+
+	// Invalid JSON
+	expectStatus(t, "POST", "data/collectionTest/scores/", nil, `{`, http.StatusBadRequest)
 }
 
 func TestAppGet(t *testing.T) {
@@ -334,10 +1150,6 @@ func TestTLS(t *testing.T) {
 	// No answer expected on POST shutdown (short timeout)
 	httpsClient = &http.Client{Timeout: 1 * time.Second, Transport: tr}
 	httpsClient.Post(fmt.Sprintf("%s/service/shutdown", baseHttpsURL), "", nil)
-
-	// Reset the serveMux
-	http.DefaultServeMux = new(http.ServeMux)
-
 }
 
 func TestDirAndJsonFile(t *testing.T) {
@@ -383,4 +1195,579 @@ func TestDirAndJsonFile(t *testing.T) {
 	assertEqualsStr(t, "", "", file)
 	assertExpectErr(t, "", err)
 
+	// Reserved names
+	_, _, err = dirAndJsonFile("/data/.history/file")
+	assertExpectErr(t, "", err)
+
+	_, _, err = dirAndJsonFile("/data/adir/.hidden")
+	assertExpectErr(t, "", err)
+
+	_, _, err = dirAndJsonFile("/data/adir/_schema")
+	assertExpectErr(t, "", err)
+
+}
+
+func TestDataTTL(t *testing.T) {
+	startServerWithConfig(t, `{"ttl":{"ttlTest/invites":"1s"},"ttlSweepInterval":"100ms"}`)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "ttlTest"))
+	defer os.RemoveAll(path.Join(dataPath, "ttlTest"))
+	defer os.RemoveAll(path.Join(dataPath, metaDir, "ttlTest"))
+
+	// TTL of a write
+	expectStatus(t, "POST", "data/ttlTest/session?ttl=300ms", nil, `{"user":"alice"}`, http.StatusOK)
+	expectStatus(t, "POST", "data/ttlTest/keep", map[string]string{"X-TTL": "1h"}, `{}`, http.StatusOK)
+	expectStatus(t, "POST", "data/ttlTest/forever", nil, `{}`, http.StatusOK)
+	expectStatus(t, "GET", "data/ttlTest/session", nil, "", http.StatusOK)
+	body, _ := expectStatus(t, "GET", "data/ttlTest/?ls=true", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `{"dirs":[],"files":["forever.json","keep.json","session.json"]}`, body)
+
+	// Expired objects are gone at once, even before they are swept
+	time.Sleep(400 * time.Millisecond)
+	expectStatus(t, "GET", "data/ttlTest/session", nil, "", http.StatusNotFound)
+	expectStatus(t, "DELETE", "data/ttlTest/session", nil, "", http.StatusNotFound)
+	body, _ = expectStatus(t, "GET", "data/ttlTest/?ls=true", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `{"dirs":[],"files":["forever.json","keep.json"]}`, body)
+	body, _ = expectStatus(t, "GET", "data/ttlTest/", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", "{\n\"forever\":{},\n\"keep\":{}\n}", body)
+	assertFileNotExist(t, "Swept", path.Join(dataPath, "ttlTest", "session.json"))
+
+	// A write of an expired object creates a new object without TTL
+	expectStatus(t, "POST", "data/ttlTest/session?ttl=50ms", nil, `{}`, http.StatusOK)
+	time.Sleep(100 * time.Millisecond)
+	expectStatus(t, "POST", "data/ttlTest/session", map[string]string{"If-None-Match": "*"}, `{}`, http.StatusOK)
+	time.Sleep(100 * time.Millisecond)
+	expectStatus(t, "GET", "data/ttlTest/session", nil, "", http.StatusOK)
+
+	// TTL of a directory
+	expectStatus(t, "POST", "data/ttlTest/invites/1", nil, `{}`, http.StatusOK)
+	expectStatus(t, "POST", "data/ttlTest/invites/", nil, `{}`, http.StatusCreated)
+	body, _ = expectStatus(t, "GET", "data/ttlTest/invites/?ls=true", nil, "", http.StatusOK)
+	assertTrue(t, "Two invites", strings.Count(body, ".json") == 2)
+	time.Sleep(1200 * time.Millisecond)
+	body, _ = expectStatus(t, "GET", "data/ttlTest/invites/?ls=true", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `{"dirs":[],"files":[]}`, body)
+
+	// Invalid TTL
+	expectStatus(t, "POST", "data/ttlTest/x?ttl=soon", nil, `{}`, http.StatusBadRequest)
+	expectStatus(t, "PATCH", "data/ttlTest/x?ttl=-1s", nil, `{}`, http.StatusBadRequest)
+}
+
+func TestDataQuota(t *testing.T) {
+	startServerWithConfig(t, `{"quotas":{"quotaTest":{"maxBytes":30,"maxObjects":2}}}`)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "quotaTest"))
+	defer os.RemoveAll(path.Join(dataPath, "quotaTest"))
+
+	expectStatus(t, "POST", "data/quotaTest/a", nil, `{"v":1}`, http.StatusOK)
+	expectStatus(t, "POST", "data/quotaTest/b", nil, `{"v":2}`, http.StatusOK)
+	expectStatus(t, "POST", "data/quotaTest/c", nil, `{"v":3}`, http.StatusInsufficientStorage)
+	expectStatus(t, "POST", "data/quotaTest/", nil, `{"v":3}`, http.StatusInsufficientStorage)
+	assertFileNotExist(t, "", path.Join(dataPath, "quotaTest", "c.json"))
+	expectStatus(t, "POST", "data/quotaTest/a", nil, `{"v":"0123456789abcdef"}`, http.StatusInsufficientStorage)
+	expectStatus(t, "PATCH", "data/quotaTest/a", map[string]string{"Content-Type": mergePatchType}, `{"w":"0123456789abcdef"}`, http.StatusInsufficientStorage)
+	expectStatus(t, "POST", "service/batch", nil,
+		`{"ops":[{"op":"put","path":"quotaTest/a","body":{"v":"0123456789abcdef"}}]}`,
+		http.StatusInsufficientStorage)
+	expectStatus(t, "POST", "data/quotaTest/a", nil, `{"v":11}`, http.StatusOK)
+	expectStatus(t, "POST", "data/quotaTestRoot", nil, `{"v":1}`, http.StatusForbidden)
+	assertFileNotExist(t, "", path.Join(dataPath, "quotaTestRoot.json"))
+
+	body, _ := expectStatus(t, "GET", "service/usage", nil, "", http.StatusOK)
+	var usages map[string]usage
+	err := json.Unmarshal([]byte(body), &usages)
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "", 2, usages["quotaTest"].Objects)
+	assertEqualsInt(t, "", 15, int(usages["quotaTest"].Bytes))
+	assertEqualsInt(t, "", 30, int(usages["quotaTest"].MaxBytes))
+	assertEqualsInt(t, "", 2, usages["quotaTest"].MaxObjects)
+
+	// Deleting data makes room for new data
+	expectStatus(t, "DELETE", "data/quotaTest/b", nil, "", http.StatusOK)
+	expectStatus(t, "POST", "data/quotaTest/c", nil, `{"v":3}`, http.StatusOK)
+}
+
+// Sets the modification time of files and directories to t
+func setModTime(t time.Time, fullPaths ...string) {
+	for _, fullPath := range fullPaths {
+		os.Chtimes(fullPath, t, t)
+	}
+}
+
+func TestDataConditionalGet(t *testing.T) {
+	startServerWithConfig(t, `{"ttl":{"condTest/invites":"1m"}}`)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "condTest"))
+	defer os.RemoveAll(path.Join(dataPath, "condTest"))
+
+	// Last-Modified is only given for contents modified before the
+	// current second
+	expectStatus(t, "POST", "data/condTest/game/1", nil, `{"turn":"alice"}`, http.StatusOK)
+	_, header := expectStatus(t, "GET", "data/condTest/game/1", nil, "", http.StatusOK)
+	assertEqualsStr(t, "Same second", "", header.Get("Last-Modified"))
+	now := time.Now().UTC().Format(http.TimeFormat)
+	expectStatus(t, "POST", "data/condTest/game/1", nil, `{"turn":"carol"}`, http.StatusOK)
+	expectStatus(t, "GET", "data/condTest/game/1", map[string]string{"If-Modified-Since": now}, "",
+		http.StatusOK)
+	expectStatus(t, "GET", "data/condTest/game/", map[string]string{"If-Modified-Since": now}, "",
+		http.StatusOK)
+
+	setModTime(time.Now().Add(-time.Minute), path.Join(dataPath, "condTest", "game", "1.json"),
+		path.Join(dataPath, "condTest", "game"), path.Join(dataPath, "condTest"))
+	for _, url := range []string{"data/condTest/game/1", "data/condTest/game/",
+		"data/condTest/game/?ls=true", "data/condTest/?depth=all"} {
+		body, header := expectStatus(t, "GET", url, nil, "", http.StatusOK)
+		assertTrue(t, url, body != "")
+		etag, lastModified := header.Get("ETag"), header.Get("Last-Modified")
+		assertTrue(t, url+" Last-Modified", lastModified != "")
+		body, _ = expectStatus(t, "GET", url, map[string]string{"If-None-Match": etag}, "",
+			http.StatusNotModified)
+		assertEqualsStr(t, "No body", "", body)
+		expectStatus(t, "GET", url, map[string]string{"If-Modified-Since": lastModified}, "",
+			http.StatusNotModified)
+		expectStatus(t, "GET", url, map[string]string{"If-None-Match": `"other"`}, "", http.StatusOK)
+	}
+
+	// Modified object and directory
+	_, header = expectStatus(t, "GET", "data/condTest/game/", nil, "", http.StatusOK)
+	expectStatus(t, "POST", "data/condTest/game/1", nil, `{"turn":"bob"}`, http.StatusOK)
+	expectStatus(t, "GET", "data/condTest/game/", map[string]string{"If-None-Match": header.Get("ETag")}, "",
+		http.StatusOK)
+	_, header = expectStatus(t, "GET", "data/condTest/?depth=all", nil, "", http.StatusOK)
+	since := time.Now().Add(-2 * time.Second).UTC().Format(http.TimeFormat)
+	time.Sleep(10 * time.Millisecond)
+	expectStatus(t, "POST", "data/condTest/game/2", nil, `{}`, http.StatusOK)
+	expectStatus(t, "GET", "data/condTest/?depth=all", map[string]string{"If-Modified-Since": since}, "",
+		http.StatusOK)
+	expectStatus(t, "GET", "data/condTest/?depth=all", map[string]string{"If-None-Match": header.Get("ETag")}, "",
+		http.StatusOK)
+
+	// An object expiring by the TTL of the directory modifies the directory
+	expectStatus(t, "POST", "data/condTest/invites/alice", nil, `{}`, http.StatusOK)
+	setModTime(time.Now().Add(-time.Minute+1500*time.Millisecond),
+		path.Join(dataPath, "condTest", "invites", "alice.json"), path.Join(dataPath, "condTest", "invites"))
+	_, header = expectStatus(t, "GET", "data/condTest/invites/", nil, "", http.StatusOK)
+	since = header.Get("Last-Modified")
+	expectStatus(t, "GET", "data/condTest/invites/", map[string]string{"If-Modified-Since": since}, "",
+		http.StatusNotModified)
+	time.Sleep(2 * time.Second)
+	var m map[string]interface{}
+	getObject(t, "data/condTest/invites/", http.StatusOK, &m)
+	assertEqualsInt(t, "Expired", 0, len(m))
+	expectStatus(t, "GET", "data/condTest/invites/", map[string]string{"If-Modified-Since": since}, "",
+		http.StatusOK)
+}
+
+// serverSentEvent is an event read from an event stream
+type serverSentEvent struct {
+	id    string
+	event string
+	data  map[string]interface{}
+}
+
+// Opens an event stream. The stream is closed after 5 seconds at latest.
+func openEventStream(t *testing.T, url string, lastEventID string) (*bufio.Reader, func()) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	req, _ := http.NewRequestWithContext(ctx, "GET", baseURL+"/"+url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assertExpectNoErr(t, url, err)
+	assertEqualsInt(t, url, http.StatusOK, resp.StatusCode)
+	assertEqualsStr(t, url, "text/event-stream", resp.Header.Get("Content-Type"))
+	return bufio.NewReader(resp.Body), func() {
+		cancel()
+		resp.Body.Close()
+	}
+}
+
+// Reads the next event from an event stream
+func readEvent(t *testing.T, reader *bufio.Reader) serverSentEvent {
+	t.Helper()
+	var e serverSentEvent
+	for {
+		line, err := reader.ReadString('\n')
+		assertExpectNoErr(t, "", err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" && e.event != "" {
+			return e
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "id":
+			e.id = value
+		case "event":
+			e.event = value
+		case "data":
+			assertExpectNoErr(t, value, json.Unmarshal([]byte(value), &e.data))
+		}
+	}
+}
+
+func TestDataEvents(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "eventTest"))
+	defer os.RemoveAll(path.Join(dataPath, "eventTest"))
+
+	stream, closeStream := openEventStream(t, "events/data/eventTest/game/", "")
+	expectStatus(t, "POST", "data/eventTest/other", nil, `{}`, http.StatusOK)
+	expectStatus(t, "POST", "data/eventTest/game/1", nil, `{"turn":"alice"}`, http.StatusOK)
+	expectStatus(t, "POST", "data/eventTest/game/1", nil, `{"turn":"bob"}`, http.StatusOK)
+	expectStatus(t, "DELETE", "data/eventTest/game/1", nil, "", http.StatusOK)
+	expectStatus(t, "DELETE", "data/eventTest/", nil, "", http.StatusOK)
+
+	created := readEvent(t, stream)
+	assertEqualsStr(t, "", "created", created.event)
+	assertEqualsStr(t, "", "/data/eventTest/game/1", created.data["path"].(string))
+	assertEqualsStr(t, "", etagOf([]byte(`{"turn":"alice"}`)), created.data["etag"].(string))
+	updated := readEvent(t, stream)
+	assertEqualsStr(t, "", "updated", updated.event)
+	deleted := readEvent(t, stream)
+	assertEqualsStr(t, "", "deleted", deleted.event)
+	assertEqualsStr(t, "", "/data/eventTest/game/1", deleted.data["path"].(string))
+	_, hasETag := deleted.data["etag"]
+	assertFalse(t, "", hasETag)
+	deleted = readEvent(t, stream)
+	assertEqualsStr(t, "Parent deleted", "/data/eventTest/", deleted.data["path"].(string))
+	closeStream()
+
+	// Resume with the events missed after the first event
+	stream, closeStream = openEventStream(t, "events/data/eventTest/game/", created.id)
+	assertEqualsStr(t, "", updated.id, readEvent(t, stream).id)
+	closeStream()
+
+	// Unknown Last-Event-ID
+	stream, closeStream = openEventStream(t, "events/data/eventTest/game/", "unknown-1")
+	assertEqualsStr(t, "", "reset", readEvent(t, stream).event)
+	closeStream()
+
+	// Invalid paths
+	expectStatus(t, "GET", "events/data/eventTest/game", nil, "", http.StatusBadRequest)
+	expectStatus(t, "GET", "events/data/.history/", nil, "", http.StatusForbidden)
+}
+
+func TestWebSocketRoom(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "wsTest"))
+	defer os.RemoveAll(path.Join(dataPath, "wsTest"))
+
+	alice, _ := dialWebSocket(t, "ws/wsTest/game1", "")
+	defer alice.close()
+	welcome := readWebSocketJSON(t, alice)
+	assertEqualsStr(t, "", "welcome", welcome["type"].(string))
+	assertEqualsInt(t, "No other members", 0, len(welcome["members"].([]interface{})))
+	_, hasState := welcome["state"]
+	assertFalse(t, "No state", hasState)
+	aliceID := welcome["id"].(string)
+
+	bob, _ := dialWebSocket(t, "ws/wsTest/game1", "")
+	defer bob.close()
+	welcome = readWebSocketJSON(t, bob)
+	assertEqualsStr(t, "", aliceID, welcome["members"].([]interface{})[0].(string))
+	bobID := welcome["id"].(string)
+	joined := readWebSocketJSON(t, alice)
+	assertEqualsStr(t, "", "join", joined["type"].(string))
+	assertEqualsStr(t, "", bobID, joined["id"].(string))
+
+	// Messages are relayed to the other members only
+	bob.writeText([]byte(`{"type":"message","data":{"move":3}}`))
+	message := readWebSocketJSON(t, alice)
+	assertEqualsStr(t, "", "message", message["type"].(string))
+	assertEqualsStr(t, "", bobID, message["from"].(string))
+	assertEqualsStr(t, "", `map[move:3]`, fmt.Sprint(message["data"]))
+
+	// State is persisted and relayed
+	alice.writeText([]byte(`{"type":"state","data":{"board":[1,0,2]}}`))
+	message = readWebSocketJSON(t, bob)
+	assertEqualsStr(t, "", "state", message["type"].(string))
+	body, _ := expectStatus(t, "GET", "data/wsTest/rooms/game1", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `{"board":[1,0,2]}`, body)
+
+	// Invalid messages
+	alice.writeText([]byte(`{"type":"state"`))
+	assertEqualsStr(t, "", "error", readWebSocketJSON(t, alice)["type"].(string))
+	alice.writeText([]byte(`{"type":"other","data":1}`))
+	assertEqualsStr(t, "", "error", readWebSocketJSON(t, alice)["type"].(string))
+
+	// Members joining later gets the state, and members of other rooms
+	// don't get any messages
+	carol, _ := dialWebSocket(t, "ws/wsTest/game1", "")
+	welcome = readWebSocketJSON(t, carol)
+	assertEqualsStr(t, "", `map[board:[1 0 2]]`, fmt.Sprint(welcome["state"]))
+	assertEqualsInt(t, "", 2, len(welcome["members"].([]interface{})))
+	readWebSocketJSON(t, alice)
+	readWebSocketJSON(t, bob)
+	dave, _ := dialWebSocket(t, "ws/wsTest/game2", "")
+	defer dave.close()
+	readWebSocketJSON(t, dave)
+
+	// Leave
+	carol.writeFrame(wsClose, nil)
+	carol.close()
+	left := readWebSocketJSON(t, alice)
+	assertEqualsStr(t, "", "leave", left["type"].(string))
+	assertEqualsStr(t, "", welcome["id"].(string), left["id"].(string))
+	readWebSocketJSON(t, bob)
+
+	dave.writeText([]byte(`{"type":"message","data":"hello"}`))
+	bob.writeText([]byte(`{"type":"message","data":"hi"}`))
+	assertEqualsStr(t, "Not from dave", "hi", readWebSocketJSON(t, alice)["data"].(string))
+
+	// Invalid requests
+	_, resp := dialWebSocket(t, "ws/wsTest/.hidden", "")
+	assertEqualsInt(t, "", http.StatusForbidden, resp.StatusCode)
+	_, resp = dialWebSocket(t, "ws/wsTest/game1", "http://evil.example")
+	assertEqualsInt(t, "", http.StatusForbidden, resp.StatusCode)
+	erin, _ := dialWebSocket(t, "ws/wsTest/game1", "http://localhost")
+	defer erin.close()
+	assertEqualsStr(t, "", "welcome", readWebSocketJSON(t, erin)["type"].(string))
+	expectStatus(t, "GET", "ws/wsTest/game1", nil, "", http.StatusBadRequest)
+}
+
+func TestDataGetWait(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "waitTest"))
+	defer os.RemoveAll(path.Join(dataPath, "waitTest"))
+
+	_, header := expectStatus(t, "POST", "data/waitTest/game/1", nil, `{"turn":"alice"}`, http.StatusOK)
+	etag := header.Get("ETag")
+
+	// Already changed, returns at once
+	body, _ := expectStatus(t, "GET", "data/waitTest/game/1?wait=10s&since=%22other%22", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `{"turn":"alice"}`, body)
+
+	// Timeout
+	start := time.Now()
+	_, header = expectStatus(t, "GET", "data/waitTest/game/1?wait=200ms&since="+url.QueryEscape(etag), nil, "",
+		http.StatusNotModified)
+	assertTrue(t, "Waited", time.Since(start) >= 200*time.Millisecond)
+	assertEqualsStr(t, "", etag, header.Get("ETag"))
+
+	// Woken by a write of the object and of an object in the directory
+	_, header = expectStatus(t, "GET", "data/waitTest/game/", nil, "", http.StatusOK)
+	dirEtag := header.Get("ETag")
+	type result struct {
+		status int
+		body   string
+	}
+	waitFor := func(path string) chan result {
+		done := make(chan result)
+		go func() {
+			resp, err := http.Get(fmt.Sprintf("%s/%s", baseURL, path))
+			if err != nil {
+				done <- result{}
+				return
+			}
+			done <- result{resp.StatusCode, respToString(resp.Body)}
+		}()
+		return done
+	}
+	objectDone := waitFor("data/waitTest/game/1?wait=10s&since=" + url.QueryEscape(etag))
+	dirDone := waitFor("data/waitTest/game/?wait=10s&since=" + strings.Trim(dirEtag, `"`))
+	otherDone := waitFor("data/waitTest/game/1?wait=10s")
+	time.Sleep(100 * time.Millisecond)
+
+	// Changes of other objects don't wake the object waiter
+	start = time.Now()
+	expectStatus(t, "POST", "data/waitTest/game/2", nil, `{}`, http.StatusOK)
+	res := <-dirDone
+	assertEqualsInt(t, "", http.StatusOK, res.status)
+	assertEqualsStr(t, "", "{\n\"1\":{\"turn\":\"alice\"},\n\"2\":{}\n}", res.body)
+	time.Sleep(100 * time.Millisecond)
+	expectStatus(t, "POST", "data/waitTest/game/1", nil, `{"turn":"bob"}`, http.StatusOK)
+	res = <-objectDone
+	assertEqualsInt(t, "", http.StatusOK, res.status)
+	assertEqualsStr(t, "", `{"turn":"bob"}`, res.body)
+	assertTrue(t, "Woken, not timed out", time.Since(start) < 5*time.Second)
+	res = <-otherDone
+	assertEqualsStr(t, "Without since", `{"turn":"bob"}`, res.body)
+
+	// Waiting for an object to be created
+	createdDone := waitFor("data/waitTest/game/3?wait=10s")
+	time.Sleep(100 * time.Millisecond)
+	expectStatus(t, "POST", "data/waitTest/game/3", nil, `{"new":true}`, http.StatusOK)
+	res = <-createdDone
+	assertEqualsStr(t, "", `{"new":true}`, res.body)
+
+	// Woken by a delete
+	deletedDone := waitFor("data/waitTest/game/3?wait=10s")
+	time.Sleep(100 * time.Millisecond)
+	expectStatus(t, "DELETE", "data/waitTest/game/3", nil, "", http.StatusOK)
+	res = <-deletedDone
+	assertEqualsInt(t, "", http.StatusNotFound, res.status)
+
+	expectStatus(t, "GET", "data/waitTest/game/1?wait=forever", nil, "", http.StatusBadRequest)
+}
+
+func TestDataWebhooks(t *testing.T) {
+	receiver := newWebhookReceiver()
+	defer receiver.server.Close()
+	startServerWithConfig(t, `{"webhooks":[{"prefix":"hookTest/scores/","url":"`+
+		receiver.server.URL+`","secret":"s3cret"}]}`)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "hookTest"))
+	defer os.RemoveAll(path.Join(dataPath, "hookTest"))
+
+	expectStatus(t, "POST", "data/hookTest/scores/alice", nil, `{"score":1}`, http.StatusOK)
+	expectStatus(t, "POST", "data/hookTest/other", nil, `{}`, http.StatusOK)
+	expectStatus(t, "POST", "data/hookTest/scores/alice?op=incr&field=score", nil, `1`, http.StatusOK)
+	expectStatus(t, "DELETE", "data/hookTest/scores/alice", nil, "", http.StatusOK)
+	expectStatus(t, "POST", "data/hookTest/scores/alice", map[string]string{"If-Match": `"x"`}, `{}`,
+		http.StatusPreconditionFailed)
+	os.MkdirAll(path.Join(dataPath, "hookTest", "scores", "occupied.json"), 0777)
+	expectStatus(t, "POST", "service/batch", nil, `{"ops":[`+
+		`{"op":"put","path":"hookTest/scores/bob","body":{"score":1}},`+
+		`{"op":"put","path":"hookTest/scores/occupied","body":{}}]}`, http.StatusInternalServerError)
+	expectStatus(t, "DELETE", "data/hookTest/", nil, "", http.StatusOK)
+
+	events := receiver.waitFor(t, 4)
+	time.Sleep(50 * time.Millisecond)
+	events = receiver.received()
+	assertEqualsInt(t, "", 4, len(events))
+	assertEqualsStr(t, "", "create", events[0].Event)
+	assertEqualsStr(t, "", "/data/hookTest/scores/alice", events[0].Path)
+	assertEqualsStr(t, "", "update", events[1].Event)
+	assertEqualsStr(t, "", `{"score":2}`, string(events[1].Data))
+	assertEqualsStr(t, "", "delete", events[2].Event)
+	assertEqualsStr(t, "", "delete", events[3].Event)
+	assertEqualsStr(t, "", "/data/hookTest/", events[3].Path)
+	for i, body := range receiver.bodies {
+		assertEqualsStr(t, "", webhookSignature("s3cret", body), receiver.headers[i].Get("X-WAS-Signature"))
+	}
+}
+
+func TestDataSchema(t *testing.T) {
+	startServer(t)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "schemaTest"))
+	defer os.RemoveAll(path.Join(dataPath, "schemaTest"))
+
+	schema := `{"type":"object","required":["board"],"properties":{"board":{"type":"array",` +
+		`"items":{"enum":[0,1,2]}}},"x-subdirectories":true}`
+	expectStatus(t, "GET", "service/schema/schemaTest/game", nil, "", http.StatusNotFound)
+	expectStatus(t, "POST", "service/schema/schemaTest/game", nil, schema, http.StatusOK)
+	expectStatus(t, "POST", "service/schema/schemaTest/game", nil, `{"pattern":"("}`,
+		http.StatusUnprocessableEntity)
+	body, _ := expectStatus(t, "POST", "service/schema/schemaTest/game", nil,
+		`{"anyOf":[{"$ref":"#"},{"$ref":"#"}]}`, http.StatusUnprocessableEntity)
+	assertTrue(t, body, strings.Contains(body, "$ref cycle"))
+	body, _ = expectStatus(t, "GET", "service/schema/schemaTest/game/", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", schema, body)
+	expectStatus(t, "GET", "service/schema/schemaTest/..%2F..%2F..", nil, "", http.StatusForbidden)
+
+	// The schema can't be accessed through the data API
+	expectStatus(t, "GET", "data/schemaTest/game/_schema", nil, "", http.StatusForbidden)
+	expectStatus(t, "POST", "data/schemaTest/game/_schema", nil, `{}`, http.StatusForbidden)
+	expectStatus(t, "PATCH", "data/schemaTest/game/_schema", nil, `{}`, http.StatusForbidden)
+	expectStatus(t, "DELETE", "data/schemaTest/game/_schema", nil, "", http.StatusForbidden)
+	expectStatus(t, "POST", "service/batch", nil,
+		`{"ops":[{"op":"delete","path":"schemaTest/game/_schema"}]}`, http.StatusForbidden)
+	assertFileExist(t, "", path.Join(dataPath, "schemaTest", "game", schemaFile))
+
+	expectStatus(t, "POST", "data/schemaTest/game/1", nil, `{"board":[0,1,2]}`, http.StatusOK)
+	body, _ = expectStatus(t, "POST", "data/schemaTest/game/2", nil, `{"board":[0,3],"x":1}`,
+		http.StatusUnprocessableEntity)
+	assertEqualsStr(t, "",
+		`{"errors":[{"path":"/board/1","message":"value not allowed"}],"message":"Schema validation failed"}`, body)
+	expectStatus(t, "POST", "data/schemaTest/game/sub/2", nil, `{}`, http.StatusUnprocessableEntity)
+	expectStatus(t, "POST", "data/schemaTest/game/", nil, `[]`, http.StatusUnprocessableEntity)
+	expectStatus(t, "POST", "data/schemaTest/other", nil, `[]`, http.StatusOK)
+
+	// Patches and operations
+	expectStatus(t, "PATCH", "data/schemaTest/game/1", map[string]string{"Content-Type": mergePatchType},
+		`{"board":null}`, http.StatusUnprocessableEntity)
+	expectStatus(t, "POST", "data/schemaTest/game/1?op=append&field=board", nil, `[5]`,
+		http.StatusUnprocessableEntity)
+	expectStatus(t, "POST", "data/schemaTest/game/1?op=append&field=board", nil, `[1]`, http.StatusOK)
+	body, _ = expectStatus(t, "POST", "service/batch", nil,
+		`{"ops":[{"op":"put","path":"schemaTest/game/3","body":{"board":[]}},`+
+			`{"op":"patch","path":"schemaTest/game/1","body":{"board":"x"}}]}`,
+		http.StatusUnprocessableEntity)
+	assertTrue(t, body, strings.Contains(body, "/board: expected array but was string"))
+	assertFileNotExist(t, "", path.Join(dataPath, "schemaTest", "game", "3.json"))
+
+	// The schema is not included in the directory
+	var m map[string]interface{}
+	getObject(t, "data/schemaTest/game/", http.StatusOK, &m)
+	_, hasSchema := m["_schema"]
+	assertFalse(t, "", hasSchema)
+	assertEqualsInt(t, "", 1, len(m))
+	body, _ = expectStatus(t, "GET", "data/schemaTest/game/?ls=true", nil, "", http.StatusOK)
+	assertEqualsStr(t, "", `{"dirs":[],"files":["1.json"]}`, body)
+	body, _ = expectStatus(t, "GET", "data/schemaTest/game/?ls=detail", nil, "", http.StatusOK)
+	assertFalse(t, body, strings.Contains(body, "_schema"))
+	body, _ = expectStatus(t, "GET", "data/schemaTest/?ls=detail", nil, "", http.StatusOK)
+	assertTrue(t, body, strings.Contains(body, `"name":"game","mtime"`))
+	assertTrue(t, body, strings.Contains(body, `"children":1}`))
+
+	// Without the schema any object can be written
+	expectStatus(t, "DELETE", "service/schema/schemaTest/game", nil, "", http.StatusOK)
+	expectStatus(t, "DELETE", "service/schema/schemaTest/game", nil, "", http.StatusNotFound)
+	expectStatus(t, "POST", "data/schemaTest/game/2", nil, `[]`, http.StatusOK)
+}
+
+func TestDataAuth(t *testing.T) {
+	startServerWithConfig(t, `{"requireAuth":true}`)
+	defer shutdownServer(t)
+
+	os.RemoveAll(path.Join(dataPath, "authTest"))
+	defer os.RemoveAll(path.Join(dataPath, "authTest"))
+	defer os.RemoveAll(path.Join(dataPath, authDir))
+
+	// Register logs in
+	body, header := expectStatus(t, "POST", "service/auth/register", nil,
+		`{"user":"alice","password":"wonderland"}`, http.StatusCreated)
+	assertEqualsStr(t, "", `{"user":"alice"}`, body)
+	cookie := header.Get("Set-Cookie")
+	assertTrue(t, cookie, strings.HasPrefix(cookie, sessionCookie+"="))
+	assertTrue(t, cookie, strings.Contains(cookie, "HttpOnly"))
+	expectStatus(t, "POST", "service/auth/register", nil, `{"user":"alice","password":"wonderland"}`,
+		http.StatusConflict)
+	expectStatus(t, "POST", "service/auth/register", nil, `{"user":"bob","password":"x"}`,
+		http.StatusBadRequest)
+
+	// Login
+	expectStatus(t, "POST", "service/auth/login", nil, `{"user":"alice","password":"wrong"}`,
+		http.StatusUnauthorized)
+	expectStatus(t, "POST", "service/auth/login", nil, `{"user":"nobody","password":"wonderland"}`,
+		http.StatusUnauthorized)
+	_, header = expectStatus(t, "POST", "service/auth/login", nil, `{"user":"alice","password":"wonderland"}`,
+		http.StatusOK)
+	session := map[string]string{"Cookie": strings.Split(header.Get("Set-Cookie"), ";")[0]}
+	body, _ = expectStatus(t, "GET", "service/auth/user", session, "", http.StatusOK)
+	assertEqualsStr(t, "", `{"user":"alice"}`, body)
+	expectStatus(t, "GET", "service/auth/user", nil, "", http.StatusUnauthorized)
+
+	// Modifications require login, reads don't
+	expectStatus(t, "POST", "data/authTest/game", nil, `{}`, http.StatusUnauthorized)
+	expectStatus(t, "POST", "service/batch", nil, `{"ops":[]}`, http.StatusUnauthorized)
+	expectStatus(t, "POST", "data/authTest/game", session, `{"turn":1}`, http.StatusOK)
+	expectStatus(t, "PATCH", "data/authTest/game", nil, `{}`, http.StatusUnauthorized)
+	expectStatus(t, "DELETE", "data/authTest/game", nil, "", http.StatusUnauthorized)
+	_, header = expectStatus(t, "GET", "data/authTest/game", nil, "", http.StatusOK)
+	assertEqualsStr(t, "Stamped", "alice", header.Get("X-Modified-By"))
+	var details listDetails
+	getObject(t, "data/authTest/?ls=detail", http.StatusOK, &details)
+	assertEqualsStr(t, "", "alice", details.Files[0].ModifiedBy)
+
+	// The accounts can't be accessed as data
+	expectStatus(t, "GET", "data/.auth/users/alice", nil, "", http.StatusForbidden)
+
+	// Logout
+	expectStatus(t, "POST", "service/auth/logout", session, "", http.StatusOK)
+	expectStatus(t, "GET", "service/auth/user", session, "", http.StatusUnauthorized)
+	expectStatus(t, "POST", "data/authTest/game", session, `{}`, http.StatusUnauthorized)
 }
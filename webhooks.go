@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Directory inside the data directory where the queue of webhook calls
+// is stored, one file per call. The calls thus survive a restart.
+const webhooksDir = ".webhooks"
+
+// Timeout of one webhook call
+const webhookTimeout = 10 * time.Second
+
+// webhookEvent is the body posted to a webhook
+type webhookEvent struct {
+	Event string          `json:"event"`          // create, update or delete
+	Path  string          `json:"path"`           // Object (or directory ending with /) path, such as /data/myapp/obj
+	Time  time.Time       `json:"time"`           // Time of the modification
+	ETag  string          `json:"etag,omitempty"` // ETag of the new contents
+	Data  json.RawMessage `json:"data,omitempty"` // New contents (not for delete)
+}
+
+// webhookCall is a queued call of a webhook
+type webhookCall struct {
+	URL       string          `json:"url"`
+	Event     string          `json:"event"`
+	Signature string          `json:"signature,omitempty"` // X-WAS-Signature header
+	Body      json.RawMessage `json:"body"`
+	Attempts  int             `json:"attempts"` // Failed attempts so far
+	Next      time.Time       `json:"next"`     // Time of next attempt
+}
+
+// webhooks queues and performs webhook calls
+type webhooks struct {
+	dir        string        // Directory of the queue
+	rules      []WebhookRule // Configured webhooks
+	maxRetries int           // Max retries of a failed call
+	retryDelay time.Duration // Delay before the first retry
+	client     *http.Client
+	ids        idGenerator   // Generates the names of the queued calls
+	mutex      sync.Mutex    // Serializes access to the queue
+	wake       chan struct{} // Signals that a call has been queued
+}
+
+func newWebhooks(dir string, config *Config) *webhooks {
+	return &webhooks{
+		dir:        dir,
+		rules:      config.Webhooks,
+		maxRetries: config.WebhookRetries,
+		retryDelay: time.Duration(config.WebhookRetryDelay),
+		client:     &http.Client{Timeout: webhookTimeout},
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Calculates the X-WAS-Signature header of a body
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Checks if the webhook rule applies to object (or directory ending with
+// /) p. Deleting a directory applies to all rules within the directory.
+func (rule WebhookRule) matches(p string) bool {
+	return withinPrefix(strings.TrimPrefix(strings.TrimPrefix(rule.Prefix, "/"), "data/"), p)
+}
+
+// Queues calls of all webhooks matching object rel (or directory rel
+// ending with /) relative the data directory. data is the new contents
+// of the object (nil for delete).
+func (wh *webhooks) trigger(event string, rel string, data []byte) {
+	p := strings.TrimSuffix(rel, ".json")
+	var body []byte
+	for _, rule := range wh.rules {
+		if !rule.matches(p) {
+			continue
+		}
+		if body == nil {
+			e := webhookEvent{Event: event, Path: "/data/" + p, Time: time.Now().UTC()}
+			if data != nil {
+				e.ETag = etagOf(data)
+				e.Data = data
+			}
+			body, _ = json.Marshal(e)
+		}
+		call := webhookCall{URL: rule.URL, Event: event, Body: body, Next: time.Now()}
+		if rule.Secret != "" {
+			call.Signature = webhookSignature(rule.Secret, body)
+		}
+		err := wh.write(wh.ids.next(), call)
+		if err != nil {
+			slog.Info("Unable to queue webhook call to " + rule.URL + ": " + err.Error())
+		}
+	}
+	if body != nil {
+		select {
+		case wh.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Writes a queued call
+func (wh *webhooks) write(id string, call webhookCall) error {
+	wh.mutex.Lock()
+	defer wh.mutex.Unlock()
+	err := os.MkdirAll(wh.dir, 0777)
+	if err != nil {
+		return err
+	}
+	callJson, _ := json.Marshal(call)
+	return writeFileAtomic(path.Join(wh.dir, id+".json"), callJson, 0666)
+}
+
+// Lists the IDs of the queued calls in the order they were queued
+func (wh *webhooks) queued() []string {
+	wh.mutex.Lock()
+	defer wh.mutex.Unlock()
+	var ids []string
+	files, _ := os.ReadDir(wh.dir)
+	for _, file := range files {
+		if !file.IsDir() && path.Ext(file.Name()) == ".json" && !strings.HasPrefix(file.Name(), ".") {
+			ids = append(ids, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// Performs one webhook call
+func (wh *webhooks) call(call webhookCall) error {
+	req, err := http.NewRequest("POST", call.URL, bytes.NewReader(call.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-WAS-Event", call.Event)
+	if call.Signature != "" {
+		req.Header.Set("X-WAS-Signature", call.Signature)
+	}
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Performs all calls that are due. Failed calls are retried later, with
+// a doubled delay each time, until the max number of retries is reached.
+// Returns the time until the next call is due (0 if the queue is empty).
+func (wh *webhooks) deliver() time.Duration {
+	var untilNext time.Duration
+	for _, id := range wh.queued() {
+		fullPath := path.Join(wh.dir, id+".json")
+		var call webhookCall
+		dat, err := os.ReadFile(fullPath)
+		if err == nil {
+			err = json.Unmarshal(dat, &call)
+		}
+		if err != nil {
+			slog.Info("Removing invalid webhook call " + id)
+			os.Remove(fullPath)
+			continue
+		}
+		if wait := time.Until(call.Next); wait > 0 {
+			if untilNext == 0 || wait < untilNext {
+				untilNext = wait
+			}
+			continue
+		}
+		err = wh.call(call)
+		if err == nil {
+			os.Remove(fullPath)
+			continue
+		}
+		call.Attempts++
+		if call.Attempts > wh.maxRetries {
+			slog.Info(fmt.Sprintf("Giving up webhook call to %s after %d attempts: %s",
+				call.URL, call.Attempts, err))
+			os.Remove(fullPath)
+			continue
+		}
+		delay := wh.retryDelay << (call.Attempts - 1)
+		slog.Debug(fmt.Sprintf("Webhook call to %s failed (%s), retry in %s", call.URL, err, delay))
+		call.Next = time.Now().Add(delay)
+		wh.write(id, call)
+		if untilNext == 0 || delay < untilNext {
+			untilNext = delay
+		}
+	}
+	return untilNext
+}
+
+// Performs the queued calls until stop is closed
+func (wh *webhooks) run(stop chan struct{}) {
+	for {
+		untilNext := wh.deliver()
+		var retry <-chan time.Time
+		if untilNext > 0 {
+			retry = time.After(untilNext)
+		}
+		select {
+		case <-wh.wake:
+		case <-retry:
+		case <-stop:
+			return
+		}
+	}
+}
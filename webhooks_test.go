@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver is a local stand-in for a webhook
+type webhookReceiver struct {
+	server   *httptest.Server
+	mutex    sync.Mutex
+	failures int // Number of calls to fail before succeeding
+	events   []webhookEvent
+	headers  []http.Header
+	bodies   [][]byte
+}
+
+func newWebhookReceiver() *webhookReceiver {
+	receiver := &webhookReceiver{}
+	receiver.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receiver.mutex.Lock()
+		defer receiver.mutex.Unlock()
+		if receiver.failures > 0 {
+			receiver.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var event webhookEvent
+		json.Unmarshal(body, &event)
+		receiver.events = append(receiver.events, event)
+		receiver.headers = append(receiver.headers, r.Header)
+		receiver.bodies = append(receiver.bodies, body)
+	}))
+	return receiver
+}
+
+// Returns the received events
+func (receiver *webhookReceiver) received() []webhookEvent {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	return append([]webhookEvent{}, receiver.events...)
+}
+
+// Waits until count events are received
+func (receiver *webhookReceiver) waitFor(t *testing.T, count int) []webhookEvent {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		if events := receiver.received(); len(events) >= count {
+			return events
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d webhook events but got %d", count, len(receiver.received()))
+	return nil
+}
+
+func TestWebhookRuleMatches(t *testing.T) {
+	rule := WebhookRule{Prefix: "myapp/scores/"}
+	assertTrue(t, "", rule.matches("myapp/scores/1"))
+	assertTrue(t, "", rule.matches("myapp/scores/a/b"))
+	assertFalse(t, "", rule.matches("myapp/other"))
+	assertTrue(t, "Parent directory deleted", rule.matches("myapp/"))
+	assertFalse(t, "Object", rule.matches("myapp"))
+	assertTrue(t, "", WebhookRule{Prefix: "/data/myapp/"}.matches("myapp/x"))
+	assertTrue(t, "", WebhookRule{}.matches("anything"))
+}
+
+func TestWebhookSignature(t *testing.T) {
+	// Example from RFC 4231 (test case 2)
+	assertEqualsStr(t, "",
+		"sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843",
+		webhookSignature("Jefe", []byte("what do ya want for nothing?")))
+}
+
+func TestWebhooks(t *testing.T) {
+	receiver := newWebhookReceiver()
+	defer receiver.server.Close()
+	config := DefaultConfig()
+	config.Webhooks = []WebhookRule{
+		{Prefix: "myapp/scores/", URL: receiver.server.URL, Secret: "secret"},
+		{Prefix: "other/", URL: receiver.server.URL},
+	}
+	config.WebhookRetries = 2
+	config.WebhookRetryDelay = Duration(10 * time.Millisecond)
+	dir := t.TempDir()
+	wh := newWebhooks(dir, config)
+
+	wh.trigger("create", "myapp/scores/1.json", []byte(`{"score":1}`))
+	wh.trigger("update", "myapp/game/1.json", []byte(`{}`))
+	wh.trigger("delete", "other/x.json", nil)
+	assertEqualsInt(t, "Queued", 2, len(wh.queued()))
+	assertEqualsInt(t, "All done", 0, int(wh.deliver()))
+	assertEqualsInt(t, "", 0, len(wh.queued()))
+
+	events := receiver.received()
+	assertEqualsInt(t, "", 2, len(events))
+	assertEqualsStr(t, "", "create", events[0].Event)
+	assertEqualsStr(t, "", "/data/myapp/scores/1", events[0].Path)
+	assertEqualsStr(t, "", `{"score":1}`, string(events[0].Data))
+	assertEqualsStr(t, "", etagOf([]byte(`{"score":1}`)), events[0].ETag)
+	assertEqualsStr(t, "", "create", receiver.headers[0].Get("X-WAS-Event"))
+	assertEqualsStr(t, "", webhookSignature("secret", receiver.bodies[0]),
+		receiver.headers[0].Get("X-WAS-Signature"))
+	assertEqualsStr(t, "", "delete", events[1].Event)
+	assertEqualsStr(t, "No data", "", string(events[1].Data))
+	assertEqualsStr(t, "No signature", "", receiver.headers[1].Get("X-WAS-Signature"))
+
+	// Retries with backoff
+	receiver.failures = 2
+	wh.trigger("update", "myapp/scores/1.json", []byte(`{"score":2}`))
+	assertEqualsInt(t, "First retry", int(10*time.Millisecond), int(wh.deliver()))
+	time.Sleep(15 * time.Millisecond)
+	assertEqualsInt(t, "Second retry", int(20*time.Millisecond), int(wh.deliver()))
+
+	// The queue is persisted
+	wh = newWebhooks(dir, config)
+	assertEqualsInt(t, "", 1, len(wh.queued()))
+	time.Sleep(25 * time.Millisecond)
+	wh.deliver()
+	assertEqualsInt(t, "", 0, len(wh.queued()))
+	assertEqualsInt(t, "", 3, len(receiver.received()))
+
+	// Give up after max retries
+	receiver.failures = 3
+	wh.trigger("update", "myapp/scores/1.json", []byte(`{"score":3}`))
+	for i := 0; i < 3; i++ {
+		time.Sleep(wh.deliver())
+	}
+	assertEqualsInt(t, "Dropped", 0, len(wh.queued()))
+	assertEqualsInt(t, "", 3, len(receiver.received()))
+}
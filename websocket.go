@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal WebSocket (RFC 6455) implementation, supporting what is needed
+// by the rooms: text messages, fragmentation, ping/pong and close.
+
+// GUID used to calculate Sec-WebSocket-Accept (RFC 6455 section 1.3)
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// Max time to wait for a client to receive a message
+const wsWriteTimeout = 10 * time.Second
+
+// Max payload of a control frame (RFC 6455 section 5.5)
+const wsMaxControlPayload = 125
+
+// wsConn is a WebSocket connection
+type wsConn struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	writeMutex sync.Mutex // Serializes writes of frames
+	maxSize    int64      // Max size of a received message
+	client     bool       // Client side of the connection, i.e. writes are masked
+}
+
+// Calculates the Sec-WebSocket-Accept header value of a key
+func wsAcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Checks if a comma separated header contains token (case insensitive)
+func headerContainsToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, candidate := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(candidate), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Checks the Origin header of a WebSocket handshake. Browsers send the
+// origin of the web page, which must be the server itself or one of the
+// allowed origins ("*" allows all). Clients which aren't browsers don't
+// send any origin and are allowed.
+func originAllowed(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, candidate := range allowed {
+		if candidate == "*" || strings.EqualFold(strings.TrimSuffix(candidate, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Upgrades a HTTP request to a WebSocket connection. An error response
+// is written if the request isn't a valid WebSocket handshake or if it
+// comes from an origin which isn't allowed.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, maxSize int64,
+	origins []string) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") || key == "" {
+		messageResponse(w, http.StatusBadRequest, "WebSocket handshake expected")
+		return nil, errors.New("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		messageResponse(w, http.StatusUpgradeRequired, "Unsupported WebSocket version")
+		return nil, errors.New("unsupported websocket version")
+	}
+	if !originAllowed(r, origins) {
+		messageResponse(w, http.StatusForbidden, "Origin not allowed")
+		return nil, errors.New("origin not allowed: " + r.Header.Get("Origin"))
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		messageResponse(w, http.StatusInternalServerError, "WebSocket not supported")
+		return nil, errors.New("hijacking not supported")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n"
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err = conn.Write([]byte(response))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, reader: rw.Reader, maxSize: maxSize}, nil
+}
+
+// Reads one frame. Returns fin, opcode and payload.
+func (c *wsConn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	_, err := io.ReadFull(c.reader, header[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, errors.New("reserved bits set")
+	}
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		return false, 0, nil, errors.New("invalid masking")
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if opcode&0x8 != 0 && (!fin || length > wsMaxControlPayload) {
+		return false, 0, nil, errors.New("fragmented or too large control frame")
+	}
+	if length > uint64(c.maxSize) {
+		return false, 0, nil, errors.New("message too large")
+	}
+	var mask [4]byte
+	if masked {
+		_, err = io.ReadFull(c.reader, mask[:])
+		if err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// Reads the next text or binary message. Ping frames are answered and
+// fragmented messages are assembled. io.EOF is returned when the
+// connection is closed by the other side.
+func (c *wsConn) readMessage() (byte, []byte, error) {
+	var message []byte
+	var messageOpcode byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			return 0, nil, io.EOF
+		case wsPing:
+			err = c.writeFrame(wsPong, payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsText, wsBinary:
+			if messageOpcode != 0 {
+				return 0, nil, errors.New("unexpected new message in fragmented message")
+			}
+			messageOpcode = opcode
+		case wsContinuation:
+			if messageOpcode == 0 {
+				return 0, nil, errors.New("unexpected continuation frame")
+			}
+		default:
+			return 0, nil, errors.New("unknown opcode")
+		}
+		message = append(message, payload...)
+		if int64(len(message)) > c.maxSize {
+			return 0, nil, errors.New("message too large")
+		}
+		if fin {
+			return messageOpcode, message, nil
+		}
+	}
+}
+
+// Writes one unfragmented frame
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	frame := []byte{0x80 | opcode}
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	length := len(payload)
+	switch {
+	case length < 126:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Writes a text message
+func (c *wsConn) writeText(message []byte) error {
+	return c.writeFrame(wsText, message)
+}
+
+// Closes the connection
+func (c *wsConn) close() error {
+	return c.conn.Close()
+}
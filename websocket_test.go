@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Connects a WebSocket client to path of the test server. The Origin
+// header is set to origin, unless it is "".
+func dialWebSocket(t *testing.T, path string, origin string) (*wsConn, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(baseURL, "http://"))
+	assertExpectNoErr(t, "", err)
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	originHeader := ""
+	if origin != "" {
+		originHeader = "Origin: " + origin + "\r\n"
+	}
+	fmt.Fprintf(conn, "GET /%s HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\n"+
+		"Connection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n%s\r\n",
+		path, key, originHeader)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	assertExpectNoErr(t, "", err)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, resp
+	}
+	assertEqualsStr(t, "", wsAcceptKey(key), resp.Header.Get("Sec-WebSocket-Accept"))
+	return &wsConn{conn: conn, reader: reader, maxSize: 1 << 20, client: true}, resp
+}
+
+// Reads the next message of a WebSocket client and decodes it
+func readWebSocketJSON(t *testing.T, c *wsConn) map[string]interface{} {
+	t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, message, err := c.readMessage()
+	assertExpectNoErr(t, "", err)
+	var result map[string]interface{}
+	err = json.Unmarshal(message, &result)
+	assertExpectNoErr(t, string(message), err)
+	return result
+}
+
+func TestWsAcceptKey(t *testing.T) {
+	// Example of RFC 6455
+	assertEqualsStr(t, "", "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", wsAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestWsFrames(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	client := &wsConn{conn: clientConn, reader: bufio.NewReader(clientConn), maxSize: 1 << 20, client: true}
+	server := &wsConn{conn: serverConn, reader: bufio.NewReader(serverConn), maxSize: 1 << 20}
+	defer client.close()
+	defer server.close()
+
+	// Messages of all length encodings, client to server
+	for _, length := range []int{0, 125, 126, 65535, 65536} {
+		message := []byte(strings.Repeat("x", length))
+		go client.writeText(message)
+		opcode, received, err := server.readMessage()
+		assertExpectNoErr(t, "", err)
+		assertEqualsInt(t, "", wsText, int(opcode))
+		assertEqualsInt(t, "", length, len(received))
+	}
+
+	// Server to client
+	go server.writeText([]byte("hello"))
+	_, received, err := client.readMessage()
+	assertExpectNoErr(t, "", err)
+	assertEqualsStr(t, "", "hello", string(received))
+
+	// Fragmented message with a ping in between
+	go func() {
+		frames := [][]byte{
+			{0x01, 0x82, 0, 0, 0, 0, 'a', 'b'}, // Text, not fin
+			{0x89, 0x80, 0, 0, 0, 0},           // Ping
+			{0x80, 0x81, 0, 0, 0, 0, 'c'},      // Continuation, fin
+		}
+		for _, frame := range frames {
+			clientConn.Write(frame)
+		}
+	}()
+	done := make(chan []byte)
+	go func() {
+		_, message, _ := server.readMessage()
+		done <- message
+	}()
+	_, opcode, pong, err := client.readFrame()
+	assertExpectNoErr(t, "", err)
+	assertEqualsInt(t, "Pong", wsPong, int(opcode))
+	assertEqualsInt(t, "", 0, len(pong))
+	assertEqualsStr(t, "", "abc", string(<-done))
+
+	// Unmasked frames from a client are rejected
+	go clientConn.Write([]byte{0x81, 0x01, 'a'})
+	_, _, err = server.readMessage()
+	assertExpectErr(t, "", err)
+}
+
+func TestWsControlFrames(t *testing.T) {
+	for _, frame := range [][]byte{
+		append([]byte{0x89, 0xFE, 0, 126, 0, 0, 0, 0}, make([]byte, 126)...), // Too large ping
+		{0x09, 0x80, 0, 0, 0, 0}, // Fragmented ping
+		{0x08, 0x80, 0, 0, 0, 0}, // Fragmented close
+	} {
+		clientConn, serverConn := net.Pipe()
+		server := &wsConn{conn: serverConn, reader: bufio.NewReader(serverConn), maxSize: 1 << 20}
+		go clientConn.Write(frame)
+		_, _, err := server.readMessage()
+		assertExpectErr(t, fmt.Sprint(frame[:2]), err)
+		clientConn.Close()
+		server.close()
+	}
+}
+
+func TestOriginAllowed(t *testing.T) {
+	request := func(origin string) *http.Request {
+		r := httptest.NewRequest("GET", "http://myhost:9834/ws/app/room", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+	assertTrue(t, "No origin", originAllowed(request(""), nil))
+	assertTrue(t, "Same host", originAllowed(request("https://MyHost:9834"), nil))
+	assertFalse(t, "Other port", originAllowed(request("http://myhost:80"), nil))
+	assertFalse(t, "Other host", originAllowed(request("https://example.com"), nil))
+	assertTrue(t, "Allowed", originAllowed(request("https://example.com"), []string{"https://example.com/"}))
+	assertFalse(t, "Other scheme", originAllowed(request("http://example.com"), []string{"https://example.com"}))
+	assertTrue(t, "All", originAllowed(request("null"), []string{"*"}))
+}
+
+func TestWsClose(t *testing.T) {
+	newConns := func() (*wsConn, *wsConn) {
+		clientConn, serverConn := net.Pipe()
+		return &wsConn{conn: clientConn, reader: bufio.NewReader(clientConn), maxSize: 10, client: true},
+			&wsConn{conn: serverConn, reader: bufio.NewReader(serverConn), maxSize: 10}
+	}
+	client, server := newConns()
+	go client.writeText([]byte("too long message"))
+	_, _, err := server.readMessage()
+	assertExpectErr(t, "Too large", err)
+	client.close()
+	server.close()
+
+	client, server = newConns()
+	defer client.close()
+	defer server.close()
+	go client.writeFrame(wsClose, nil)
+	go client.readFrame() // Close reply
+	_, _, err = server.readMessage()
+	assertTrue(t, "", err == io.EOF)
+}